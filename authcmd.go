@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"onivex/network"
+)
+
+const authUsage = `usage: onivex auth <command> [-file data/client_auth.json]
+
+  init                  create our x25519 client key and print its public half
+  allow <name> <pubkey> let a member's key reach our onion (makes it private)
+  revoke <name>         remove a member; no members means a public onion
+  list                  show our public key and the authorized members
+
+Restart the node after changing the member list.`
+
+// runAuthCommand handles `onivex auth <subcommand>` for v3 onion client auth
+func runAuthCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, authUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("auth "+args[0], flag.ExitOnError)
+	path := fs.String("file", network.DefaultClientAuthPath(), "Client auth store")
+	fs.Parse(args[1:])
+
+	ca, err := network.LoadClientAuth(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "init":
+		err = authInit(ca)
+	case "allow":
+		if fs.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, authUsage)
+			return 2
+		}
+		err = authAllow(ca, fs.Arg(0), fs.Arg(1))
+	case "revoke":
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, authUsage)
+			return 2
+		}
+		delete(ca.Authorized, fs.Arg(0))
+		err = ca.Save()
+	case "list":
+		err = authList(ca)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown auth command %q\n\n%s\n", args[0], authUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func authInit(ca *network.ClientAuth) error {
+	if ca.PrivateKey == "" {
+		priv, _, err := network.GenerateAuthKey()
+		if err != nil {
+			return err
+		}
+		ca.PrivateKey = priv
+		if err := ca.Save(); err != nil {
+			return err
+		}
+	}
+	pub, err := ca.PublicKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println("🔑 Give this public key to the members of your private mesh:")
+	fmt.Println(pub)
+	return nil
+}
+
+func authAllow(ca *network.ClientAuth, name, pub string) error {
+	if err := network.ValidateAuthKey(pub); err != nil {
+		return err
+	}
+	if ca.Authorized == nil {
+		ca.Authorized = make(map[string]string)
+	}
+	ca.Authorized[name] = pub
+	if err := ca.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s may now reach this node\n", name)
+	return nil
+}
+
+func authList(ca *network.ClientAuth) error {
+	if pub, err := ca.PublicKey(); err == nil {
+		fmt.Printf("Our client key: %s\n", pub)
+	} else {
+		fmt.Println("Our client key: none (run `onivex auth init`)")
+	}
+	if !ca.Private() {
+		fmt.Println("Onion service: public")
+		return nil
+	}
+	names := make([]string, 0, len(ca.Authorized))
+	for name := range ca.Authorized {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Onion service: private, %d member(s)\n", len(names))
+	for _, name := range names {
+		fmt.Printf("  %-20s %s\n", name, ca.Authorized[name])
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package bloom
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"hash/fnv"
 	"math"
 )
@@ -48,4 +52,69 @@ func (f *Filter) Test(data []byte) bool {
 		}
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// FillRatio is the fraction of bits set
+func (f *Filter) FillRatio() float64 {
+	if len(f.BitSet) == 0 {
+		return 0
+	}
+	set := 0
+	for _, bit := range f.BitSet {
+		if bit {
+			set++
+		}
+	}
+	return float64(set) / float64(len(f.BitSet))
+}
+
+// EstimatedFPRate is the chance a term that was never added still tests
+// positive, given how full the filter is
+func (f *Filter) EstimatedFPRate() float64 {
+	return math.Pow(f.FillRatio(), float64(f.K))
+}
+
+// Digest returns a short content hash of the filter. Peers gossip digests so
+// a full filter only needs to be pulled when its digest changes.
+func (f *Filter) Digest() string {
+	h := sha256.New()
+	var hdr [16]byte
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(f.M))
+	binary.BigEndian.PutUint64(hdr[8:16], uint64(f.K))
+	h.Write(hdr[:])
+	h.Write(f.Pack().Bits)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+// Packed is the compact wire form of a Filter: one bit per slot instead of a
+// JSON bool, roughly 40x smaller. Bits are base64 encoded by encoding/json.
+type Packed struct {
+	Bits []byte `json:"bits"`
+	K    uint   `json:"k"`
+	M    uint   `json:"m"`
+}
+
+// Pack converts the filter to its compact wire form
+func (f *Filter) Pack() *Packed {
+	packed := make([]byte, (len(f.BitSet)+7)/8)
+	for i, bit := range f.BitSet {
+		if bit {
+			packed[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+	return &Packed{Bits: packed, K: f.K, M: f.M}
+}
+
+// Unpack expands a packed filter, rejecting inconsistent sizes
+func (p *Packed) Unpack() (*Filter, error) {
+	if p.M == 0 || uint(len(p.Bits)) != (p.M+7)/8 {
+		return nil, fmt.Errorf("packed filter size mismatch")
+	}
+	if p.K == 0 || p.K > 64 {
+		return nil, fmt.Errorf("packed filter has invalid k=%d", p.K)
+	}
+	f := &Filter{BitSet: make([]bool, p.M), K: p.K, M: p.M}
+	for i := uint(0); i < p.M; i++ {
+		f.BitSet[i] = p.Bits[i/8]&(1<<(i%8)) != 0
+	}
+	return f, nil
+}
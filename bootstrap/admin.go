@@ -0,0 +1,76 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"onivex/discovery"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// serveAdmin runs the operator dashboard on addr. It refuses to bind
+// anything but a loopback address and, in case of a proxy in front of it,
+// also refuses requests that don't come from loopback. Requests must name
+// a loopback host and the admin port too, so a rebound DNS name can't
+// read the dashboard from a browser.
+func serveAdmin(addr string, stats *SeedStats, fed *discovery.Federation) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin address %s is not loopback", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats.Snapshot())
+	})
+	mux.HandleFunc("/api/federation", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if peer := r.URL.Query().Get("peer"); peer != "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"peer": peer, "seeds": fed.Attribution(peer)})
+			return
+		}
+		json.NewEncoder(w).Encode(fed.Status())
+	})
+
+	slog.Info("📊 Seed dashboard", "url", "http://"+addr)
+	return http.ListenAndServe(addr, localOnly(port, mux))
+}
+
+func localOnly(port string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() || r.Header.Get("X-Forwarded-For") != "" || !localHost(r, port) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localHost reports whether the Host header names loopback on the admin
+// port, as the client's control UI does
+func localHost(r *http.Request, port string) bool {
+	host, p, err := net.SplitHostPort(r.Host)
+	if err != nil || p != port {
+		return false
+	}
+	return host == "127.0.0.1" || host == "localhost" || host == "::1"
+}
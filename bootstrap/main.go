@@ -2,59 +2,144 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"onivex/bloom" // Add bloom import
+	"onivex/config"
 	"onivex/discovery"
+	"onivex/logging"
 	"onivex/network"
 )
 
 func main() {
-	fmt.Println("🌳 STARTING ONIVEX SEED NODE 🌳")
+	transportKind := flag.String("transport", "tor", "Mesh transport: tor, or loopback for local testing without network access")
+	adminAddr := flag.String("admin", "127.0.0.1:8090", "Localhost address for the operator dashboard (empty to disable)")
+	manifestPath := flag.String("seed-manifest", "", "Signed seed manifest to serve at /api/seeds/manifest (see `onivex seeds sign`); clients only accept it if its key is in their seed_manifest_keys")
+	var siblings []string
+	flag.Func("federate", "Sibling seed to mirror peer tables with; repeatable (seeds in a verified -seed-manifest are added automatically)", func(v string) error {
+		siblings = append(siblings, v)
+		return nil
+	})
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logRedact := flag.String("log-redact", "hash", "Mask onions, queries and paths in logs: off, truncate or hash")
+	var logSinks []string
+	flag.Func("log-sink", "Where logs go: stdout, stderr or a file path; repeatable (default stdout)", func(v string) error {
+		logSinks = append(logSinks, v)
+		return nil
+	})
+	torOpts := network.RegisterTorFlags(flag.CommandLine)
+	network.RegisterKeyFlags(flag.CommandLine)
+	flag.Parse()
 
-	t, onion, err := network.SetupTor("seed_identity")
+	err := config.Active().Override(func(s *config.Settings) {
+		s.Logging.Level, s.Logging.Format, s.Logging.Redact = *logLevel, *logFormat, *logRedact
+		if len(logSinks) > 0 {
+			s.Logging.Sinks = logSinks
+		}
+	})
+	if err == nil {
+		err = logging.Setup()
+	}
 	if err != nil {
-		log.Fatalf("Fatal Network Error: %v", err)
+		log.Fatalf("Logging: %v", err)
 	}
-	defer t.Close()
-	defer onion.Close()
 
-	myAddress := fmt.Sprintf("%v.onion", onion.ID)
-	fmt.Printf("\n⭐ SEED ADDRESS (Copy to discovery/bootstrap.go): \n   %s\n\n", myAddress)
+	slog.Info("🌳 STARTING ONIVEX SEED NODE 🌳")
+
+	transport, err := network.OpenTransport(*transportKind, "seed_identity", *torOpts)
+	if err != nil {
+		logging.Fatal("❌ Fatal network error", "err", err)
+	}
+	defer transport.Close()
 
-	peers := discovery.NewPeerManager(t)
+	myAddress := transport.Address()
+	fmt.Printf("\n⭐ SEED ADDRESS (Add to the seed manifest or discovery/bootstrap.go): \n   %s\n\n", myAddress)
+
+	if *manifestPath != "" {
+		data, err := os.ReadFile(*manifestPath)
+		if err != nil {
+			logging.Fatal("❌ Seed manifest", "err", err)
+		}
+		m, err := discovery.ParseSeedManifest(data)
+		if err == nil {
+			err = m.Verify(discovery.MaintainerKeys)
+		}
+		// An unverified manifest is still served, but its seeds aren't
+		// trusted enough to mirror peer tables with
+		if err != nil {
+			slog.Warn("⚠️  Serving seed manifest anyway; only clients that list its key in seed_manifest_keys will accept it", "err", err)
+		} else {
+			siblings = append(siblings, m.Seeds...)
+			slog.Info("🌱 Serving seed manifest", "serial", m.Serial, "seeds", len(m.Seeds))
+		}
+	}
+
+	peers := discovery.NewPeerManager(transport)
+	peers.DHT = peers.NewDHT(myAddress)
+	peers.Capabilities = []string{config.CapFilterDigests, config.CapCompressedFilters, config.CapDHT}
 	peers.AddPeer(myAddress)
 	peers.StartCleanup(10*time.Minute, 60*time.Minute)
 
+	fed := discovery.NewFederation(peers, transport.Address, siblings)
+	if len(fed.Siblings()) > 0 {
+		slog.Info("🌐 Federating with sibling seeds", "siblings", len(fed.Siblings()))
+		fed.Start(5 * time.Minute)
+	}
+
+	stats := NewSeedStats(peers.GetPeers())
+	if *adminAddr != "" {
+		go func() { logging.Fatal("❌ Seed dashboard stopped", "err", serveAdmin(*adminAddr, stats, fed)) }()
+	}
+
+	// Seeds share nothing, so their filter (and its digest) never changes
+	emptyFilter := bloom.New(100, 0.01)
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OniVex Seed Node Active"))
 	})
 
+	mux.HandleFunc("/api/hello", peers.HelloHandler(myAddress))
+
 	mux.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
 			var payload map[string]string
 			if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
 				addr := payload["addr"]
 				if addr != "" && addr != myAddress {
-					fmt.Printf("👋 New Client Announced: %s\n", addr)
+					slog.Info("👋 New client announced", "peer", addr)
 					peers.AddPeer(addr)
+					stats.Announce(addr, r.Header.Get("X-Onivex-Version"))
 				}
 			}
 		}
+		w.Header().Set(discovery.DigestHeader, emptyFilter.Digest())
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(peers.GetRandomPeers(200))
+		if r.URL.Query().Get("digests") == "1" {
+			json.NewEncoder(w).Encode(discovery.GossipResponse{FilterDigest: emptyFilter.Digest(), Peers: fed.Sample(200)})
+			return
+		}
+		json.NewEncoder(w).Encode(fed.Sample(200))
 	})
 
 	// --- NEW: Empty Bloom Filter ---
 	mux.HandleFunc("/api/filter", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(discovery.DigestHeader, emptyFilter.Digest())
 		w.Header().Set("Content-Type", "application/json")
 		// Return empty filter
-		json.NewEncoder(w).Encode(bloom.New(100, 0.01))
+		if r.URL.Query().Get("format") == "packed" {
+			json.NewEncoder(w).Encode(emptyFilter.Pack())
+			return
+		}
+		json.NewEncoder(w).Encode(emptyFilter)
 	})
 
 	mux.HandleFunc("/api/index", func(w http.ResponseWriter, r *http.Request) {
@@ -67,12 +152,31 @@ func main() {
 		w.Write([]byte("[]"))
 	})
 
+	mux.HandleFunc(discovery.FederationPath, fed.Handler())
+
+	if *manifestPath != "" {
+		mux.HandleFunc("/api/seeds/manifest", discovery.ManifestHandler(*manifestPath))
+	}
+
+	// Seeds are well-connected and long-lived, so they make good DHT routers
+	peers.DHT.Register(mux)
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			stats.Sample(peers.GetPeers())
+		}
+	}()
+
 	go func() {
 		for {
 			time.Sleep(1 * time.Hour)
-			fmt.Printf("⏱️  Seed Node Heartbeat. Tracking %d active peers.\n", len(peers.GetPeers()))
+			snap := stats.Snapshot()
+			slog.Info("⏱️  Seed node heartbeat", "peers", snap.KnownPeers, "requests_per_min", snap.RequestsPerMinute)
 		}
 	}()
 
-	log.Fatal(http.Serve(onion, mux))
+	limiter := discovery.NewLimiter(transport.Address)
+	limiter.Trusted = peers.Trusted
+	pow := discovery.NewPoWGuard()
+	logging.Fatal("❌ Seed stopped", "err", http.Serve(transport.Listener(), stats.Middleware(limiter.Middleware(pow.Middleware(discovery.VersionMiddleware(mux))))))
 }
\ No newline at end of file
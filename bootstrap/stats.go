@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsHours is how much hourly history the dashboard keeps
+const statsHours = 24
+
+// hourStats is one hour of seed activity
+type hourStats struct {
+	Start         time.Time `json:"start"`
+	Announcements int       `json:"announcements"`
+	UniquePeers   int       `json:"unique_peers"`
+	Joined        int       `json:"joined"`
+	Left          int       `json:"left"`
+	Requests      int       `json:"requests"`
+
+	peers map[string]bool
+}
+
+// SeedStats tracks what a seed sees: announcements, churn of the peer
+// table, the protocol versions peers run and request rates. All counters
+// are in memory and start over when the seed restarts.
+type SeedStats struct {
+	mu       sync.Mutex
+	started  time.Time
+	hours    []*hourStats // oldest first, at most statsHours
+	minutes  [60]int      // requests per minute, indexed by minute of the hour
+	minuteAt [60]time.Time
+
+	announcements int
+	requests      map[string]int    // by endpoint
+	versions      map[string]string // announcer -> last X-Onivex-Version
+	known         map[string]bool   // peer table at the last sample
+}
+
+// NewSeedStats starts tracking from the peer table as it is now, so peers
+// remembered from the last run don't count as joins
+func NewSeedStats(peers []string) *SeedStats {
+	s := &SeedStats{
+		started:  time.Now(),
+		requests: make(map[string]int),
+		versions: make(map[string]string),
+		known:    make(map[string]bool),
+	}
+	for _, p := range peers {
+		s.known[p] = true
+	}
+	return s
+}
+
+// hourLocked returns the bucket for the current hour, starting a new one
+// (and dropping the oldest) when the hour rolls over
+func (s *SeedStats) hourLocked(now time.Time) *hourStats {
+	start := now.Truncate(time.Hour)
+	if n := len(s.hours); n > 0 && s.hours[n-1].Start.Equal(start) {
+		return s.hours[n-1]
+	}
+	h := &hourStats{Start: start, peers: make(map[string]bool)}
+	s.hours = append(s.hours, h)
+	if len(s.hours) > statsHours {
+		s.hours = s.hours[len(s.hours)-statsHours:]
+	}
+	return h
+}
+
+// Announce records a client announcing itself on /api/peers
+func (s *SeedStats) Announce(addr, version string) {
+	if version == "" {
+		version = "unknown"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.hourLocked(time.Now())
+	h.Announcements++
+	h.peers[addr] = true
+	h.UniquePeers = len(h.peers)
+	s.announcements++
+	s.versions[addr] = version
+}
+
+// Sample compares the peer table with the previous sample to count joins
+// and departures (peers expired by the cleanup loop)
+func (s *SeedStats) Sample(peers []string) {
+	now := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		now[p] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.hourLocked(time.Now())
+	for p := range now {
+		if !s.known[p] {
+			h.Joined++
+		}
+	}
+	for p := range s.known {
+		if !now[p] {
+			h.Left++
+			delete(s.versions, p)
+		}
+	}
+	s.known = now
+}
+
+// Middleware counts every request by endpoint
+func (s *SeedStats) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.countRequest(endpoint(r.URL.Path))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *SeedStats) countRequest(ep string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[ep]++
+	s.hourLocked(now).Requests++
+	m := now.Minute()
+	if minute := now.Truncate(time.Minute); !s.minuteAt[m].Equal(minute) {
+		s.minuteAt[m], s.minutes[m] = minute, 0
+	}
+	s.minutes[m]++
+}
+
+// endpoint groups paths so DHT keys and the like don't each get a counter
+func endpoint(path string) string {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 3)
+	if len(parts) >= 2 && parts[0] == "api" {
+		return "/api/" + parts[1]
+	}
+	if path == "/" {
+		return "/"
+	}
+	return "other"
+}
+
+// StatsSnapshot is the JSON served at /api/stats
+type StatsSnapshot struct {
+	Started       time.Time      `json:"started"`
+	Uptime        string         `json:"uptime"`
+	KnownPeers    int            `json:"known_peers"`
+	Announcements int            `json:"announcements"`
+	Versions      map[string]int `json:"versions"`
+	Requests      map[string]int `json:"requests"`
+
+	// RequestsLastMinute and RequestsPerMinute (averaged over the last
+	// hour) give the current load
+	RequestsLastMinute int     `json:"requests_last_minute"`
+	RequestsPerMinute  float64 `json:"requests_per_minute"`
+
+	Hours []hourStats `json:"hours"`
+}
+
+// Snapshot copies the current counters
+func (s *SeedStats) Snapshot() StatsSnapshot {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := StatsSnapshot{
+		Started:       s.started,
+		Uptime:        now.Sub(s.started).Truncate(time.Second).String(),
+		KnownPeers:    len(s.known),
+		Announcements: s.announcements,
+		Versions:      make(map[string]int),
+		Requests:      make(map[string]int, len(s.requests)),
+	}
+	for _, v := range s.versions {
+		snap.Versions[v]++
+	}
+	for ep, n := range s.requests {
+		snap.Requests[ep] = n
+	}
+
+	total := 0
+	for m := range s.minutes {
+		if now.Sub(s.minuteAt[m]) < time.Hour {
+			total += s.minutes[m]
+		}
+	}
+	if last := now.Add(-time.Minute); s.minuteAt[last.Minute()].Equal(last.Truncate(time.Minute)) {
+		snap.RequestsLastMinute = s.minutes[last.Minute()]
+	}
+	window := now.Sub(s.started)
+	if window > time.Hour {
+		window = time.Hour
+	}
+	if window >= time.Minute {
+		snap.RequestsPerMinute = float64(total) / window.Minutes()
+	}
+
+	for _, h := range s.hours {
+		snap.Hours = append(snap.Hours, *h)
+	}
+	sort.Slice(snap.Hours, func(i, j int) bool { return snap.Hours[i].Start.After(snap.Hours[j].Start) })
+	return snap
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"onivex/config"
+	"onivex/filesystem"
+	"onivex/transfer"
+	"onivex/webui"
+)
+
+const clientUsage = `usage: onivex <command> [flags]
+
+Commands that drive a running node through its control API:
+
+  status                          node address, peers and transfers
+  identity [-card-name n]         our onion identity and friend card
+  search <query...>               search the mesh (or the DHT, for a content hash)
+  download [-name n] [-wait] <peer> <path>
+                                  fetch a file from a peer into downloads/
+  downloads ls                    list downloads started from the CLI or API
+  downloads pause|resume|cancel <id>
+  peers ls                        show the peer table
+  peers add|remove <onion>
+  share ls                        list shared files
+  share add [-name n] <file...>   copy files into the shared folder
+
+Every command takes -json for machine-readable output, -ui to pick the node
+(default: ui_port from -config) and -token-file (default data/ui_token, or
+set ONIVEX_UI_TOKEN).`
+
+// clientCommands are the `onivex <command>` names handled by runClientCommand
+var clientCommands = map[string]bool{
+	"status": true, "identity": true, "search": true, "download": true,
+	"downloads": true, "peers": true, "share": true,
+}
+
+// runClientCommand handles the commands that talk to a running node
+func runClientCommand(cmd string, args []string) int {
+	var err error
+	switch cmd {
+	case "status":
+		err = clientStatus(args)
+	case "identity":
+		err = clientIdentity(args)
+	case "search":
+		err = clientSearch(args)
+	case "download":
+		err = clientDownload(args)
+	case "downloads", "peers", "share":
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, clientUsage)
+			return 2
+		}
+		err = clientSubcommand(cmd, args[0], args[1:])
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(os.Stderr, clientUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+func clientSubcommand(cmd, sub string, args []string) error {
+	switch cmd + " " + sub {
+	case "downloads ls":
+		return downloadsList(args)
+	case "downloads pause", "downloads resume", "downloads cancel":
+		return downloadsControl(sub, args)
+	case "peers ls":
+		return peersList(args)
+	case "peers add":
+		return peersAdd(args)
+	case "peers remove":
+		return peersRemove(args)
+	case "share ls":
+		return shareList(args)
+	case "share add":
+		return shareAdd(args)
+	}
+	fmt.Fprintf(os.Stderr, "unknown %s command %q\n\n", cmd, sub)
+	return errUsage
+}
+
+// apiClient calls the node's /api/v1 control API
+type apiClient struct {
+	base  string
+	token string
+	json  bool
+}
+
+// clientFlags registers the flags every client command shares; call the
+// returned func after fs.Parse to get a client
+func clientFlags(fs *flag.FlagSet) func() (*apiClient, error) {
+	configPath := configFlag(fs)
+	ui := fs.String("ui", "", "Control UI address, e.g. http://127.0.0.1:8080 (default: ui_port from -config)")
+	cwd, _ := os.Getwd()
+	tokenFile := fs.String("token-file", filepath.Join(cwd, "data", webui.TokenFile), "File holding the UI access token")
+	asJSON := fs.Bool("json", false, "Print the API's JSON instead of a table")
+
+	return func() (*apiClient, error) {
+		c := &apiClient{base: strings.TrimSuffix(*ui, "/"), json: *asJSON}
+		if c.base == "" {
+			store, err := config.Load(*configPath)
+			if err != nil {
+				return nil, err
+			}
+			c.base = fmt.Sprintf("http://127.0.0.1:%d", store.Get().UIPort)
+		}
+		c.token = os.Getenv("ONIVEX_UI_TOKEN")
+		if c.token == "" {
+			data, err := os.ReadFile(*tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading the access token: %w (is the node running in this folder?)", err)
+			}
+			c.token = strings.TrimSpace(string(data))
+		}
+		if c.token == "" {
+			return nil, errors.New("the access token is empty")
+		}
+		return c, nil
+	}
+}
+
+// do sends body as JSON and decodes the response into out, turning API
+// errors into Go errors. It returns the raw response for -json output.
+func (c *apiClient) do(method, path string, body, out any) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the node at %s: %w", c.base, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr webui.APIError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Code != "" {
+			return data, fmt.Errorf("%s (%s)", apiErr.Error.Message, apiErr.Error.Code)
+		}
+		return data, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// printJSON prints a raw API response, indented
+func printJSON(data []byte) error {
+	var buf bytes.Buffer
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(buf.String())
+	return nil
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+func humanSize(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func clientStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var node webui.NodeInfo
+	nodeData, err := c.do("GET", "/api/v1/node", nil, &node)
+	if err != nil {
+		return err
+	}
+	var uploads transfer.UploadStats
+	uploadData, err := c.do("GET", "/api/v1/uploads", nil, &uploads)
+	if err != nil {
+		return err
+	}
+	var downloads []webui.Download
+	downloadData, err := c.do("GET", "/api/v1/downloads", nil, &downloads)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON([]byte(fmt.Sprintf(`{"node":%s,"uploads":%s,"downloads":%s}`, nodeData, uploadData, downloadData)))
+	}
+
+	running := 0
+	for _, d := range downloads {
+		if d.State == webui.DownloadRunning {
+			running++
+		}
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "Address:\t%s\n", node.Address)
+	fmt.Fprintf(tw, "Version:\t%s\n", node.Version)
+	fmt.Fprintf(tw, "Capabilities:\t%s\n", strings.Join(node.Capabilities, ", "))
+	fmt.Fprintf(tw, "Friends only:\t%v\n", node.FriendsOnly)
+	fmt.Fprintf(tw, "Peers:\t%d\n", node.Peers)
+	fmt.Fprintf(tw, "Downloads:\t%d running, %d total\n", running, len(downloads))
+	fmt.Fprintf(tw, "Uploads:\t%d active, %d queued, %s served\n", len(uploads.Active), len(uploads.Queued), humanSize(uploads.TotalBytes))
+	return tw.Flush()
+}
+
+func clientIdentity(args []string) error {
+	fs := flag.NewFlagSet("identity", flag.ExitOnError)
+	cardName := fs.String("card-name", "", "Name to put on the friend card")
+	client := clientFlags(fs)
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	path := "/api/v1/identity"
+	if *cardName != "" {
+		path += "?name=" + url.QueryEscape(*cardName)
+	}
+	var id webui.Identity
+	data, err := c.do("GET", path, nil, &id)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+
+	tw := newTable()
+	fmt.Fprintf(tw, "Address:\t%s\n", id.Address)
+	fmt.Fprintf(tw, "Mode:\t%s\n", id.Mode)
+	if id.KeyFile != "" {
+		fmt.Fprintf(tw, "Key file:\t%s\n", id.KeyFile)
+		fmt.Fprintf(tw, "Encrypted:\t%v\n", id.Encrypted)
+	}
+	if id.FriendCard != "" {
+		fmt.Fprintf(tw, "Friend card:\t%s\n", id.FriendCard)
+	}
+	return tw.Flush()
+}
+
+func clientSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var search webui.Search
+	data, err := c.do("POST", "/api/v1/searches", webui.SearchRequest{Query: strings.Join(fs.Args(), " ")}, &search)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+
+	tw := newTable()
+	fmt.Fprintln(tw, "PEER\tNAME\tSIZE\tPATH")
+	n := 0
+	for _, res := range search.Results {
+		for _, f := range res.Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.PeerID, f.Name, humanSize(f.Size), f.Path)
+			n++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "🔎 %d files from %d peers in %dms\n", n, len(search.Results), search.TookMS)
+	return nil
+}
+
+func clientDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	name := fs.String("name", "", "Save as this name in downloads/ (default: the remote file's name)")
+	wait := fs.Bool("wait", false, "Show progress and wait for the download to finish")
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var d webui.Download
+	data, err := c.do("POST", "/api/v1/downloads", webui.DownloadRequest{Peer: fs.Arg(0), Path: fs.Arg(1), Name: *name}, &d)
+	if err != nil {
+		return err
+	}
+	if !*wait {
+		if c.json {
+			return printJSON(data)
+		}
+		fmt.Printf("📥 Download %s started: %s\n", d.ID, d.LocalPath)
+		return nil
+	}
+
+	for d.State == webui.DownloadRunning {
+		time.Sleep(time.Second)
+		if data, err = c.do("GET", "/api/v1/downloads/"+d.ID, nil, &d); err != nil {
+			return err
+		}
+		if !c.json {
+			fmt.Fprintf(os.Stderr, "\r📥 %s: %s of %s   ", d.Name, humanSize(d.Bytes), humanSize(d.Size))
+		}
+	}
+	if c.json {
+		return printJSON(data)
+	}
+	fmt.Fprintln(os.Stderr)
+	switch d.State {
+	case webui.DownloadDone:
+		fmt.Printf("✅ Saved %s (%s)\n", d.LocalPath, humanSize(d.Bytes))
+		return nil
+	case webui.DownloadFailed:
+		return fmt.Errorf("download %s failed: %s", d.ID, d.Error)
+	}
+	return fmt.Errorf("download %s was %s", d.ID, d.State)
+}
+
+func downloadsList(args []string) error {
+	fs := flag.NewFlagSet("downloads ls", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var downloads []webui.Download
+	data, err := c.do("GET", "/api/v1/downloads", nil, &downloads)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tSTATE\tPROGRESS\tNAME\tPEER")
+	for _, d := range downloads {
+		progress := humanSize(d.Bytes)
+		if d.Size >= 0 {
+			progress += " / " + humanSize(d.Size)
+		}
+		state := d.State
+		if d.Error != "" {
+			state += ": " + d.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.ID, state, progress, d.Name, d.Peer)
+	}
+	return tw.Flush()
+}
+
+// downloadsControl pauses, resumes or cancels a download
+func downloadsControl(action string, args []string) error {
+	fs := flag.NewFlagSet("downloads "+action, flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	id := url.PathEscape(fs.Arg(0))
+	var data []byte
+	if action == "cancel" {
+		_, err = c.do("DELETE", "/api/v1/downloads/"+id, nil, nil)
+	} else {
+		data, err = c.do("POST", "/api/v1/downloads/"+id+"/"+action, nil, nil)
+	}
+	if err != nil || c.json {
+		if err == nil && data != nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+	fmt.Printf("✅ Download %s: %s\n", fs.Arg(0), map[string]string{"pause": "paused", "resume": "resumed", "cancel": "canceled"}[action])
+	return nil
+}
+
+func peersList(args []string) error {
+	fs := flag.NewFlagSet("peers ls", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var peers []webui.Peer
+	data, err := c.do("GET", "/api/v1/peers", nil, &peers)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+
+	tw := newTable()
+	fmt.Fprintln(tw, "ADDRESS\tLAST SEEN\tVERSION\tFLAGS")
+	for _, p := range peers {
+		var flags []string
+		if p.Seed {
+			flags = append(flags, "seed")
+		}
+		if p.Friend {
+			flags = append(flags, "friend")
+		}
+		if p.HasFilter {
+			flags = append(flags, "filter")
+		}
+		if p.Incompatible {
+			flags = append(flags, "incompatible")
+		}
+		version := p.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s ago\t%s\t%s\n", p.Address, time.Since(p.LastSeen).Round(time.Second), version, strings.Join(flags, ","))
+	}
+	return tw.Flush()
+}
+
+func peersAdd(args []string) error {
+	fs := flag.NewFlagSet("peers add", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	data, err := c.do("POST", "/api/v1/peers", webui.PeerRequest{Address: fs.Arg(0)}, nil)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+	fmt.Printf("🔭 Added peer %s\n", fs.Arg(0))
+	return nil
+}
+
+func peersRemove(args []string) error {
+	fs := flag.NewFlagSet("peers remove", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	if _, err := c.do("DELETE", "/api/v1/peers/"+url.PathEscape(fs.Arg(0)), nil, nil); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Printf("🗑️  Removed peer %s\n", fs.Arg(0))
+	}
+	return nil
+}
+
+func printFiles(files []filesystem.FileMeta) error {
+	tw := newTable()
+	fmt.Fprintln(tw, "NAME\tSIZE\tHASH")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, humanSize(f.Size), f.Hash)
+	}
+	return tw.Flush()
+}
+
+func shareList(args []string) error {
+	fs := flag.NewFlagSet("share ls", flag.ExitOnError)
+	client := clientFlags(fs)
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var files []filesystem.FileMeta
+	data, err := c.do("GET", "/api/v1/shares", nil, &files)
+	if err != nil || c.json {
+		if err == nil {
+			err = printJSON(data)
+		}
+		return err
+	}
+	return printFiles(files)
+}
+
+func shareAdd(args []string) error {
+	fs := flag.NewFlagSet("share add", flag.ExitOnError)
+	name := fs.String("name", "", "Share a single file under this name")
+	client := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*name != "" && fs.NArg() > 1) {
+		return errUsage
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+
+	var added []filesystem.FileMeta
+	for _, file := range fs.Args() {
+		// The node may run in another folder, so send an absolute path
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		var meta filesystem.FileMeta
+		if _, err := c.do("POST", "/api/v1/shares", webui.ShareRequest{Path: abs, Name: *name}, &meta); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		added = append(added, meta)
+	}
+	if c.json {
+		data, err := json.Marshal(added)
+		if err != nil {
+			return err
+		}
+		return printJSON(data)
+	}
+	return printFiles(added)
+}
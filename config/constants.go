@@ -1,5 +1,22 @@
 package config
 
 // ProtocolVersion acts as the single source of truth for the network protocol.
-// Bump this when making breaking changes to data structures or API routes.
-const ProtocolVersion = "1.0"
\ No newline at end of file
+// It is a semver string: bump the minor for additive changes and the major
+// for breaking changes to data structures or API routes.
+const ProtocolVersion = "1.1.0"
+
+// MinCompatibleVersion is the oldest peer version we still talk to. Peers
+// below it are refused with 426 Upgrade Required.
+const MinCompatibleVersion = "1.0.0"
+
+// Capabilities are optional protocol features negotiated via /api/hello
+const (
+	CapFilterDigests     = "filter-digests"     // /api/peers?digests=1
+	CapCompressedFilters = "compressed-filters" // /api/filter?format=packed
+	CapChunkedTransfer   = "chunked-transfer"   // HTTP Range requests on shared files
+	CapStreamingSearch   = "streaming-search"   // reserved, not implemented yet
+	CapDHT               = "dht"                // /api/dht/*
+)
+
+// Capabilities is what a full client node advertises in its hello
+var Capabilities = []string{CapFilterDigests, CapCompressedFilters, CapChunkedTransfer, CapDHT}
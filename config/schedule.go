@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleWindow is one entry of downloads.schedule, written as
+// "[days ]HH:MM-HH:MM rate_kib" in local time, e.g. "00:00-07:00 0" or
+// "mon-fri 09:00-18:00 64". Windows may run past midnight; days are those
+// the window starts on.
+type ScheduleWindow struct {
+	Days    [7]bool
+	From    int // minutes after midnight
+	To      int
+	RateKiB int
+}
+
+func parseWeekday(s string) (int, error) {
+	for i, d := range weekdays {
+		if strings.EqualFold(s, d) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("times are HH:MM, not %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseScheduleWindow reads one downloads.schedule entry
+func ParseScheduleWindow(s string) (ScheduleWindow, error) {
+	var w ScheduleWindow
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 2:
+		w.Days = [7]bool{true, true, true, true, true, true, true}
+	case 3:
+		first, last, isRange := strings.Cut(fields[0], "-")
+		from, err := parseWeekday(first)
+		if err != nil {
+			return w, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseWeekday(last); err != nil {
+				return w, err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("%q is not \"[days ]HH:MM-HH:MM rate_kib\"", s)
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("%q is not a HH:MM-HH:MM range", fields[0])
+	}
+	var err error
+	if w.From, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.To, err = parseClock(to); err != nil {
+		return w, err
+	}
+	if w.RateKiB, err = strconv.Atoi(fields[1]); err != nil || w.RateKiB < 0 {
+		return w, fmt.Errorf("rate %q must be KiB/s, 0 for unlimited", fields[1])
+	}
+	return w, nil
+}
+
+// Contains reports whether t falls in the window. From == To means all day.
+func (w ScheduleWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	switch {
+	case w.From == w.To:
+		return w.Days[day]
+	case w.From < w.To:
+		return w.Days[day] && minute >= w.From && minute < w.To
+	case minute >= w.From:
+		return w.Days[day]
+	case minute < w.To:
+		// The early-morning part of a window that started yesterday
+		return w.Days[(day+6)%7]
+	}
+	return false
+}
+
+// RateAt returns the download rate in KiB/s (0 = unlimited) in effect at
+// t: that of the first schedule window containing t, else RateKiB. The
+// matching entry is returned too, "" if none.
+func (d DownloadSettings) RateAt(t time.Time) (int, string) {
+	for _, entry := range d.Schedule {
+		if w, err := ParseScheduleWindow(entry); err == nil && w.Contains(t) {
+			return w.RateKiB, entry
+		}
+	}
+	return d.RateKiB, ""
+}
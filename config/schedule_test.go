@@ -0,0 +1,121 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a local time on the given weekday of a fixed week
+func at(day time.Weekday, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	// 2024-01-07 is a Sunday
+	return time.Date(2024, 1, 7+int(day), t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+func TestParseScheduleWindow(t *testing.T) {
+	all := [7]bool{true, true, true, true, true, true, true}
+	tests := []struct {
+		in   string
+		want ScheduleWindow
+	}{
+		{"00:00-07:00 0", ScheduleWindow{Days: all, From: 0, To: 7 * 60}},
+		{"mon-fri 09:00-18:00 64", ScheduleWindow{Days: [7]bool{false, true, true, true, true, true, false}, From: 9 * 60, To: 18 * 60, RateKiB: 64}},
+		{"fri-mon 22:30-06:00 8", ScheduleWindow{Days: [7]bool{true, true, false, false, false, true, true}, From: 22*60 + 30, To: 6 * 60, RateKiB: 8}},
+		{"Sun 10:00-10:00 0", ScheduleWindow{Days: [7]bool{true}, From: 10 * 60, To: 10 * 60}},
+		{"sat-sat 01:00-02:00 1", ScheduleWindow{Days: [7]bool{6: true}, From: 60, To: 120, RateKiB: 1}},
+	}
+	for _, tt := range tests {
+		got, err := ParseScheduleWindow(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleWindowRejectsBadInput(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"09:00-18:00",
+		"mon 09:00-18:00 64 extra",
+		"09:00 64",
+		"9am-5pm 64",
+		"25:00-06:00 0",
+		"09:00-18:60 0",
+		"09:00-18:00 -1",
+		"09:00-18:00 fast",
+		"someday 09:00-18:00 0",
+		"mon-someday 09:00-18:00 0",
+	} {
+		if w, err := ParseScheduleWindow(in); err == nil {
+			t.Errorf("%q: parsed as %+v, want an error", in, w)
+		}
+	}
+}
+
+func TestScheduleWindowContains(t *testing.T) {
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		// Same-day window; To is exclusive
+		{"mon-fri 09:00-18:00 64", at(time.Monday, "09:00"), true},
+		{"mon-fri 09:00-18:00 64", at(time.Friday, "17:59"), true},
+		{"mon-fri 09:00-18:00 64", at(time.Friday, "18:00"), false},
+		{"mon-fri 09:00-18:00 64", at(time.Saturday, "12:00"), false},
+
+		// Windows past midnight belong to the day they start on
+		{"22:00-06:00 0", at(time.Wednesday, "23:30"), true},
+		{"22:00-06:00 0", at(time.Wednesday, "05:59"), true},
+		{"22:00-06:00 0", at(time.Wednesday, "06:00"), false},
+		{"22:00-06:00 0", at(time.Wednesday, "12:00"), false},
+		{"fri 22:00-06:00 0", at(time.Friday, "22:00"), true},
+		{"fri 22:00-06:00 0", at(time.Saturday, "03:00"), true},
+		{"fri 22:00-06:00 0", at(time.Friday, "03:00"), false},
+		{"fri 22:00-06:00 0", at(time.Saturday, "23:00"), false},
+		{"sat 22:00-06:00 0", at(time.Sunday, "01:00"), true},
+
+		// Day ranges that wrap the week
+		{"fri-mon 09:00-18:00 64", at(time.Friday, "10:00"), true},
+		{"fri-mon 09:00-18:00 64", at(time.Sunday, "10:00"), true},
+		{"fri-mon 09:00-18:00 64", at(time.Monday, "10:00"), true},
+		{"fri-mon 09:00-18:00 64", at(time.Tuesday, "10:00"), false},
+		{"fri-mon 09:00-18:00 64", at(time.Thursday, "10:00"), false},
+		{"fri-mon 22:00-02:00 64", at(time.Tuesday, "01:00"), true},
+		{"fri-mon 22:00-02:00 64", at(time.Wednesday, "01:00"), false},
+
+		// From == To is the whole day
+		{"sat 10:00-10:00 0", at(time.Saturday, "00:00"), true},
+		{"sat 10:00-10:00 0", at(time.Saturday, "23:59"), true},
+		{"sat 10:00-10:00 0", at(time.Sunday, "09:00"), false},
+	}
+	for _, tt := range tests {
+		w, err := ParseScheduleWindow(tt.window)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.window, err)
+		}
+		if got := w.Contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %s: got %v, want %v", tt.window, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestRateAtUsesFirstMatchingWindow(t *testing.T) {
+	d := DownloadSettings{RateKiB: 100, Schedule: []string{"bogus", "mon 09:00-18:00 10", "09:00-18:00 20"}}
+	if rate, entry := d.RateAt(at(time.Monday, "12:00")); rate != 10 || entry != "mon 09:00-18:00 10" {
+		t.Errorf("monday: got %d from %q", rate, entry)
+	}
+	if rate, entry := d.RateAt(at(time.Tuesday, "12:00")); rate != 20 || entry != "09:00-18:00 20" {
+		t.Errorf("tuesday: got %d from %q", rate, entry)
+	}
+	if rate, entry := d.RateAt(at(time.Tuesday, "20:00")); rate != 100 || entry != "" {
+		t.Errorf("evening: got %d from %q", rate, entry)
+	}
+}
@@ -0,0 +1,485 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/network"
+)
+
+// Duration is a time.Duration that reads and writes as "15m" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are strings like \"15m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) D() time.Duration { return time.Duration(d) }
+
+// Settings is every tunable of a client node. Fields tagged restart:"true"
+// are read once at startup; the rest are read where they are used and take
+// effect as soon as they are saved.
+type Settings struct {
+	UIPort      int    `json:"ui_port" restart:"true"`
+	Transport   string `json:"transport" restart:"true"`
+	Identity    string `json:"identity" restart:"true"`
+	FriendsOnly bool   `json:"friends_only" restart:"true"`
+
+	// Seeds replaces the built-in and manifest seed lists when non-empty
+	Seeds []string `json:"seeds"`
+
+	Network   NetworkSettings  `json:"network"`
+	Search    SearchSettings   `json:"search"`
+	Limits    LimitSettings    `json:"limits"`
+	PoW       PoWSettings      `json:"pow"`
+	Uploads   UploadSettings   `json:"uploads"`
+	Downloads DownloadSettings `json:"downloads"`
+	Logging   LogSettings      `json:"logging"`
+}
+
+type NetworkSettings struct {
+	StartupDelay      Duration `json:"startup_delay" restart:"true"`
+	BootstrapInterval Duration `json:"bootstrap_interval"`
+	PersistInterval   Duration `json:"persist_interval" restart:"true"`
+	ClientTimeout     Duration `json:"client_timeout"`
+	DownloadTimeout   Duration `json:"download_timeout"`
+	GossipPeers       int      `json:"gossip_peers"`
+
+	// SeedManifestRefresh is how often signed seed manifests are fetched
+	// (0 disables); SeedManifestKeys are trusted on top of the maintainers'.
+	// No maintainer key is built in yet, so with no SeedManifestKeys no
+	// manifest is fetched or accepted.
+	SeedManifestRefresh Duration `json:"seed_manifest_refresh"`
+	SeedManifestKeys    []string `json:"seed_manifest_keys"`
+}
+
+type SearchSettings struct {
+	BloomSize     int     `json:"bloom_size"`
+	BloomFPRate   float64 `json:"bloom_fp_rate"`
+	MaxWorkers    int     `json:"max_workers"`
+	ForwardTTL    int     `json:"forward_ttl"`
+	ForwardFanout int     `json:"forward_fanout"`
+}
+
+// LimitSettings protect the onion-facing API from abusive peers. Requests
+// are bucketed by their signed origin, or by connection when unsigned.
+type LimitSettings struct {
+	MaxBodyBytes          int `json:"max_body_bytes"`
+	RequestsPerMinute     int `json:"requests_per_minute"`
+	Burst                 int `json:"burst"`
+	AnonRequestsPerMinute int `json:"anon_requests_per_minute"`
+	MaxConcurrentSearches int `json:"max_concurrent_searches"`
+}
+
+// PoWSettings set the hashcash difficulty (leading zero bits) we demand on
+// announcements and forwarded queries (0 means none). With Auto, once those
+// requests pass LoadThreshold per minute we demand at least 16 bits, plus a
+// bit for every further doubling, up to MaxDifficulty.
+type PoWSettings struct {
+	Difficulty    int  `json:"difficulty"`
+	Auto          bool `json:"auto"`
+	LoadThreshold int  `json:"load_threshold"`
+	MaxDifficulty int  `json:"max_difficulty"`
+}
+
+// UploadSettings limit what we serve to other peers. RateKiB is shared by
+// all uploads (0 = unlimited); peers beyond MaxActive wait in a queue of
+// QueueSize for up to QueueTimeout. PerPeer caps one peer's simultaneous
+// uploads and DailyQuotaMiB its bytes per UTC day (0 = unlimited).
+type UploadSettings struct {
+	RateKiB       int      `json:"rate_kib"`
+	MaxActive     int      `json:"max_active"`
+	QueueSize     int      `json:"queue_size"`
+	QueueTimeout  Duration `json:"queue_timeout"`
+	PerPeer       int      `json:"per_peer"`
+	DailyQuotaMiB int      `json:"daily_quota_mib"`
+}
+
+// DownloadSettings cap everything we fetch over the transport. RateKiB is
+// shared by all transfers (0 = unlimited) and Schedule entries override it
+// at certain times of day (see ScheduleWindow).
+type DownloadSettings struct {
+	RateKiB  int      `json:"rate_kib"`
+	Schedule []string `json:"schedule"`
+}
+
+// LogSettings control what is logged and where. Level is debug, info, warn
+// or error; Format is text or json. Redact is off, truncate or hash and
+// masks onion addresses, search queries and file paths. Sinks are stdout,
+// stderr or file paths, which are appended to.
+type LogSettings struct {
+	Level  string   `json:"level"`
+	Format string   `json:"format" restart:"true"`
+	Redact string   `json:"redact"`
+	Sinks  []string `json:"sinks" restart:"true"`
+}
+
+// Defaults are the values Onivex shipped with before settings existed
+func Defaults() Settings {
+	return Settings{
+		UIPort:    8080,
+		Transport: "tor",
+		Identity:  "persistent",
+		Seeds:     []string{},
+		Network: NetworkSettings{
+			StartupDelay:      Duration(15 * time.Second),
+			BootstrapInterval: Duration(15 * time.Minute),
+			PersistInterval:   Duration(5 * time.Minute),
+			ClientTimeout:     Duration(60 * time.Second),
+			DownloadTimeout:   Duration(15 * time.Minute),
+			GossipPeers:       50,
+
+			SeedManifestRefresh: Duration(6 * time.Hour),
+			SeedManifestKeys:    []string{},
+		},
+		Search: SearchSettings{
+			BloomSize:     1000,
+			BloomFPRate:   0.01,
+			MaxWorkers:    10,
+			ForwardTTL:    2,
+			ForwardFanout: 3,
+		},
+		Limits: LimitSettings{
+			MaxBodyBytes:          64 << 10,
+			RequestsPerMinute:     120,
+			Burst:                 60,
+			AnonRequestsPerMinute: 600,
+			MaxConcurrentSearches: 8,
+		},
+		PoW: PoWSettings{
+			Auto:          true,
+			LoadThreshold: 120,
+			MaxDifficulty: 22,
+		},
+		Uploads: UploadSettings{
+			MaxActive:    4,
+			QueueSize:    20,
+			QueueTimeout: Duration(2 * time.Minute),
+			PerPeer:      2,
+		},
+		Downloads: DownloadSettings{
+			Schedule: []string{},
+		},
+		Logging: LogSettings{
+			Level:  "info",
+			Format: "text",
+			Redact: "hash",
+			Sinks:  []string{"stdout"},
+		},
+	}
+}
+
+// Validate rejects settings that would break the node
+func (s Settings) Validate() error {
+	switch {
+	case s.UIPort < 1 || s.UIPort > 65535:
+		return fmt.Errorf("ui_port must be 1-65535")
+	case s.Transport != "tor" && s.Transport != "loopback":
+		return fmt.Errorf("transport must be tor or loopback")
+	case s.Identity != "persistent" && s.Identity != "ephemeral" && s.Identity != "rotating":
+		return fmt.Errorf("identity must be persistent, ephemeral or rotating")
+	case s.Network.BootstrapInterval.D() < time.Minute:
+		return fmt.Errorf("network.bootstrap_interval must be at least 1m")
+	case s.Network.PersistInterval.D() < 10*time.Second:
+		return fmt.Errorf("network.persist_interval must be at least 10s")
+	case s.Network.ClientTimeout.D() < 5*time.Second:
+		return fmt.Errorf("network.client_timeout must be at least 5s")
+	case s.Network.DownloadTimeout.D() < time.Minute:
+		return fmt.Errorf("network.download_timeout must be at least 1m")
+	case s.Network.GossipPeers < 1 || s.Network.GossipPeers > 1000:
+		return fmt.Errorf("network.gossip_peers must be 1-1000")
+	case s.Network.SeedManifestRefresh != 0 && s.Network.SeedManifestRefresh.D() < 10*time.Minute:
+		return fmt.Errorf("network.seed_manifest_refresh must be 0 (off) or at least 10m")
+	case s.Search.BloomSize < 100:
+		return fmt.Errorf("search.bloom_size must be at least 100")
+	case s.Search.BloomFPRate <= 0 || s.Search.BloomFPRate >= 0.5:
+		return fmt.Errorf("search.bloom_fp_rate must be between 0 and 0.5")
+	case s.Search.MaxWorkers < 1 || s.Search.MaxWorkers > 100:
+		return fmt.Errorf("search.max_workers must be 1-100")
+	case s.Search.ForwardTTL < 0 || s.Search.ForwardTTL > 5:
+		return fmt.Errorf("search.forward_ttl must be 0-5")
+	case s.Search.ForwardFanout < 1 || s.Search.ForwardFanout > 10:
+		return fmt.Errorf("search.forward_fanout must be 1-10")
+	case s.Limits.MaxBodyBytes < 1024:
+		return fmt.Errorf("limits.max_body_bytes must be at least 1024")
+	case s.Limits.RequestsPerMinute < 1 || s.Limits.Burst < 1 || s.Limits.AnonRequestsPerMinute < 1:
+		return fmt.Errorf("limits request rates and burst must be at least 1")
+	case s.Limits.MaxConcurrentSearches < 1:
+		return fmt.Errorf("limits.max_concurrent_searches must be at least 1")
+	case s.PoW.Difficulty < 0 || s.PoW.MaxDifficulty > 28 || s.PoW.Difficulty > s.PoW.MaxDifficulty:
+		return fmt.Errorf("pow: need 0 <= difficulty <= max_difficulty <= 28")
+	case s.PoW.LoadThreshold < 1:
+		return fmt.Errorf("pow.load_threshold must be at least 1")
+	case s.Uploads.RateKiB < 0 || s.Uploads.DailyQuotaMiB < 0:
+		return fmt.Errorf("uploads.rate_kib and uploads.daily_quota_mib can't be negative")
+	case s.Uploads.MaxActive < 1 || s.Uploads.PerPeer < 1 || s.Uploads.QueueSize < 0:
+		return fmt.Errorf("uploads: max_active and per_peer must be at least 1")
+	case s.Uploads.QueueTimeout.D() < time.Second:
+		return fmt.Errorf("uploads.queue_timeout must be at least 1s")
+	case s.Downloads.RateKiB < 0:
+		return fmt.Errorf("downloads.rate_kib can't be negative")
+	case s.Logging.Level != "debug" && s.Logging.Level != "info" && s.Logging.Level != "warn" && s.Logging.Level != "error":
+		return fmt.Errorf("logging.level must be debug, info, warn or error")
+	case s.Logging.Format != "text" && s.Logging.Format != "json":
+		return fmt.Errorf("logging.format must be text or json")
+	case s.Logging.Redact != "off" && s.Logging.Redact != "truncate" && s.Logging.Redact != "hash":
+		return fmt.Errorf("logging.redact must be off, truncate or hash")
+	case len(s.Logging.Sinks) == 0:
+		return fmt.Errorf("logging.sinks needs at least one sink")
+	}
+	for _, seed := range s.Seeds {
+		if !network.ValidOnion(seed) {
+			return fmt.Errorf("seeds: %q is not a v3 onion address", seed)
+		}
+	}
+	for _, entry := range s.Downloads.Schedule {
+		if _, err := ParseScheduleWindow(entry); err != nil {
+			return fmt.Errorf("downloads.schedule: %v", err)
+		}
+	}
+	for _, key := range s.Network.SeedManifestKeys {
+		if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 32 {
+			return fmt.Errorf("network.seed_manifest_keys: %q is not a base64 ed25519 public key", key)
+		}
+	}
+	return nil
+}
+
+// Store holds the settings file and the effective settings derived from
+// it. Environment variables and command-line flags are overrides: they
+// apply on top of the file but are never written back to it.
+type Store struct {
+	mu        sync.RWMutex
+	path      string
+	file      Settings
+	effective Settings
+	overrides []func(*Settings)
+}
+
+var current = &Store{file: Defaults(), effective: Defaults()}
+
+// Current returns the effective settings of the running node
+func Current() Settings { return current.Get() }
+
+// Active is the store Current reads from
+func Active() *Store { return current }
+
+// Load reads path (JSON; a missing file means defaults), applies ONIVEX_*
+// environment overrides and makes the result current.
+func Load(path string) (*Store, error) {
+	st := &Store{path: path, file: Defaults()}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &st.file); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	st.overrides = append(st.overrides, applyEnv)
+	if err := st.recompute(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	current = st
+	return st, nil
+}
+
+// Get returns a copy of the effective settings
+func (st *Store) Get() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.effective
+}
+
+// File returns the settings as stored on disk, without overrides
+func (st *Store) File() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.file
+}
+
+// Path is where Save writes
+func (st *Store) Path() string { return st.path }
+
+// Override layers fn on top of the file settings, e.g. for a CLI flag
+func (st *Store) Override(fn func(*Settings)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.overrides = append(st.overrides, fn)
+	return st.recomputeLocked()
+}
+
+// Replace validates and saves new file settings. It returns the names of
+// changed fields that only take effect after a restart.
+func (st *Store) Replace(s Settings) ([]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	old := st.file
+	prevEffective := st.effective
+	st.file = s
+	if err := st.recomputeLocked(); err != nil {
+		st.file = old
+		st.effective = prevEffective
+		return nil, err
+	}
+	if err := st.saveLocked(); err != nil {
+		st.file = old
+		st.effective = prevEffective
+		return nil, err
+	}
+	return restartFields(old, s), nil
+}
+
+func (st *Store) recompute() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.recomputeLocked()
+}
+
+func (st *Store) recomputeLocked() error {
+	eff := st.file
+	for _, fn := range st.overrides {
+		fn(&eff)
+	}
+	if err := eff.Validate(); err != nil {
+		return err
+	}
+	st.effective = eff
+	return nil
+}
+
+func (st *Store) saveLocked() error {
+	if st.path == "" {
+		return fmt.Errorf("settings were not loaded from a file")
+	}
+	data, _ := json.MarshalIndent(st.file, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
+
+// RestartFields lists the JSON paths of settings tagged restart:"true"
+func RestartFields() []string {
+	var out []string
+	walkFields(reflect.ValueOf(Settings{}), "", func(path string, f reflect.StructField, _ reflect.Value) {
+		if f.Tag.Get("restart") == "true" {
+			out = append(out, path)
+		}
+	})
+	return out
+}
+
+func restartFields(old, s Settings) []string {
+	oldVals := map[string]interface{}{}
+	walkFields(reflect.ValueOf(old), "", func(path string, _ reflect.StructField, v reflect.Value) {
+		oldVals[path] = v.Interface()
+	})
+	var changed []string
+	walkFields(reflect.ValueOf(s), "", func(path string, f reflect.StructField, v reflect.Value) {
+		if f.Tag.Get("restart") == "true" && !reflect.DeepEqual(oldVals[path], v.Interface()) {
+			changed = append(changed, path)
+		}
+	})
+	return changed
+}
+
+// walkFields visits every leaf setting with its dotted JSON path
+func walkFields(v reflect.Value, prefix string, fn func(path string, f reflect.StructField, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+		if f.Type.Kind() == reflect.Struct {
+			walkFields(v.Field(i), path+".", fn)
+			continue
+		}
+		fn(path, f, v.Field(i))
+	}
+}
+
+// applyEnv maps ONIVEX_<PATH> variables onto settings, e.g.
+// ONIVEX_UI_PORT=9090 or ONIVEX_SEARCH_MAX_WORKERS=20. Unparseable values
+// are reported and ignored.
+func applyEnv(s *Settings) {
+	walkFields(reflect.ValueOf(s).Elem(), "", func(path string, _ reflect.StructField, v reflect.Value) {
+		key := "ONIVEX_" + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+		if err := setFromString(v, raw); err != nil {
+			slog.Warn("⚠️  Ignoring environment override", "var", key, "err", err)
+		}
+	})
+}
+
+func setFromString(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err == nil {
+			v.SetInt(int64(d))
+		}
+		return err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		parts := []string{}
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		v.Set(reflect.ValueOf(parts))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semver triple (pre-release/build suffixes are ignored)
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion accepts "1", "1.0" and "1.0.0" so pre-semver peers that sent
+// "1.0" still parse.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := [3]int{}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{nums[0], nums[1], nums[2]}, nil
+}
+
+// Compare returns -1, 0 or 1
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.Major != o.Major:
+		return cmpInt(v.Major, o.Major)
+	case v.Minor != o.Minor:
+		return cmpInt(v.Minor, o.Minor)
+	default:
+		return cmpInt(v.Patch, o.Patch)
+	}
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsCompatible reports whether a peer speaking version s can talk to us:
+// at least MinCompatibleVersion and on our major version, since a major
+// bump is allowed to break the protocol
+func IsCompatible(s string) bool {
+	v, err := ParseVersion(s)
+	if err != nil {
+		return false
+	}
+	min, _ := ParseVersion(MinCompatibleVersion)
+	ours, _ := ParseVersion(ProtocolVersion)
+	return v.Compare(min) >= 0 && v.Major == ours.Major
+}
+
+func cmpInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,351 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"onivex/config"
+)
+
+const (
+	// BucketSize is Kademlia's k: contacts per bucket and lookup result size
+	BucketSize = 20
+	// Alpha is the number of RPCs kept in flight during a lookup
+	Alpha = 3
+	// ProviderTTL is how long a provider record lives without a republish
+	ProviderTTL = 24 * time.Hour
+	// RepublishInterval is how often nodes should re-announce their content
+	RepublishInterval = 12 * time.Hour
+)
+
+// DHT is a Kademlia node speaking FIND_NODE / FIND_VALUE / PROVIDE as JSON
+// over the same HTTP transport the rest of the mesh uses.
+type DHT struct {
+	Table *RoutingTable
+
+	// Sign signs every outgoing RPC. Verify authenticates PROVIDE: a record
+	// is stored for the onion that signed the request, never for an address
+	// in the body, so nobody can announce someone else as a provider.
+	// Without Verify, PROVIDE is refused.
+	Sign   func(req *http.Request)
+	Verify func(r *http.Request, self string) (string, error)
+
+	client func() *http.Client
+
+	mu        sync.Mutex
+	self      string
+	providers map[NodeID]map[string]time.Time
+}
+
+type rpcRequest struct {
+	Sender string `json:"sender"`
+	Target string `json:"target"`
+}
+
+type findNodeResponse struct {
+	Nodes []string `json:"nodes"`
+}
+
+type findValueResponse struct {
+	Providers []string `json:"providers,omitempty"`
+	Nodes     []string `json:"nodes"`
+}
+
+// New creates a DHT node for selfAddr. The client getter is invoked lazily so
+// callers can pass PeerManager.GetTorClient before Tor is fully up.
+func New(selfAddr string, client func() *http.Client) *DHT {
+	return &DHT{
+		self:      selfAddr,
+		Table:     NewRoutingTable(IDFromAddr(selfAddr)),
+		client:    client,
+		providers: make(map[NodeID]map[string]time.Time),
+	}
+}
+
+// Self is the onion address this node announces in RPCs
+func (d *DHT) Self() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.self
+}
+
+// Rekey moves the node to a new address after an identity rotation. The
+// routing table is re-bucketed around the new ID and our own provider
+// records are carried over so the next republish announces the new onion.
+func (d *DHT) Rekey(selfAddr string) {
+	d.mu.Lock()
+	old := d.self
+	d.self = selfAddr
+	for _, set := range d.providers {
+		if expires, ok := set[old]; ok {
+			delete(set, old)
+			set[selfAddr] = expires
+		}
+	}
+	d.mu.Unlock()
+	d.Table.Rekey(IDFromAddr(selfAddr))
+}
+
+// AddContact seeds the routing table, e.g. with peers learned via gossip
+func (d *DHT) AddContact(addr string) {
+	if addr != "" && addr != d.Self() {
+		d.Table.Update(addr)
+	}
+}
+
+// Register mounts the DHT RPC endpoints on the onion-facing mux
+func (d *DHT) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/dht/find_node", func(w http.ResponseWriter, r *http.Request) {
+		req, target, ok := d.decodeRPC(w, r)
+		if !ok {
+			return
+		}
+		d.AddContact(req.Sender)
+		writeJSON(w, findNodeResponse{Nodes: addrs(d.Table.Closest(target, BucketSize))})
+	})
+
+	mux.HandleFunc("/api/dht/find_value", func(w http.ResponseWriter, r *http.Request) {
+		req, key, ok := d.decodeRPC(w, r)
+		if !ok {
+			return
+		}
+		d.AddContact(req.Sender)
+		writeJSON(w, findValueResponse{
+			Providers: d.localProviders(key),
+			Nodes:     addrs(d.Table.Closest(key, BucketSize)),
+		})
+	})
+
+	mux.HandleFunc("/api/dht/provide", func(w http.ResponseWriter, r *http.Request) {
+		_, key, ok := d.decodeRPC(w, r)
+		if !ok {
+			return
+		}
+		if d.Verify == nil {
+			http.Error(w, "Provider records are not accepted", http.StatusForbidden)
+			return
+		}
+		origin, err := d.Verify(r, d.Self())
+		if err != nil {
+			http.Error(w, "Provide must be signed: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		d.AddContact(origin)
+		d.storeProvider(key, origin)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (d *DHT) decodeRPC(w http.ResponseWriter, r *http.Request) (rpcRequest, NodeID, bool) {
+	var req rpcRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return req, NodeID{}, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return req, NodeID{}, false
+	}
+	target, err := ParseID(req.Target)
+	if err != nil {
+		http.Error(w, "Bad target", http.StatusBadRequest)
+		return req, NodeID{}, false
+	}
+	return req, target, true
+}
+
+// Provide announces that this node serves the content with the given key by
+// storing a provider record on the k closest nodes.
+func (d *DHT) Provide(key NodeID) {
+	d.storeProvider(key, d.Self())
+	closest, _ := d.lookup(key, false)
+	for _, c := range closest {
+		go d.call(c.Addr, "provide", key, nil)
+	}
+}
+
+// FindProviders walks the DHT towards key and returns the onions that
+// announced they hold it.
+func (d *DHT) FindProviders(key NodeID) []string {
+	_, providers := d.lookup(key, true)
+	return providers
+}
+
+// FindNode returns the k closest live contacts to target
+func (d *DHT) FindNode(target NodeID) []Contact {
+	closest, _ := d.lookup(target, false)
+	return closest
+}
+
+// Refresh performs a self-lookup so the node becomes known to its neighbours
+func (d *DHT) Refresh() {
+	d.FindNode(IDFromAddr(d.Self()))
+}
+
+// lookup is the iterative Kademlia node/value search. Each round queries up
+// to Alpha of the closest unqueried contacts and stops once the k closest
+// known contacts have all answered (or, for values, once providers appear).
+func (d *DHT) lookup(target NodeID, findValue bool) ([]Contact, []string) {
+	shortlist := d.Table.Closest(target, BucketSize)
+	seen := map[string]bool{d.Self(): true}
+	for _, c := range shortlist {
+		seen[c.Addr] = true
+	}
+	queried := map[string]bool{}
+	failed := map[string]bool{}
+	providerSet := map[string]bool{}
+	for _, p := range d.localProviders(target) {
+		providerSet[p] = true
+	}
+
+	for {
+		batch := []Contact{}
+		for _, c := range shortlist {
+			if len(batch) >= Alpha {
+				break
+			}
+			if !queried[c.Addr] {
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 || (findValue && len(providerSet) > 0) {
+			break
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			queried[c.Addr] = true
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				method := "find_node"
+				if findValue {
+					method = "find_value"
+				}
+				var resp findValueResponse
+				if err := d.call(addr, method, target, &resp); err != nil {
+					mu.Lock()
+					failed[addr] = true
+					mu.Unlock()
+					d.Table.Remove(addr)
+					return
+				}
+				d.Table.Update(addr)
+
+				mu.Lock()
+				defer mu.Unlock()
+				for _, p := range resp.Providers {
+					providerSet[p] = true
+				}
+				for _, n := range resp.Nodes {
+					if !seen[n] {
+						seen[n] = true
+						shortlist = append(shortlist, Contact{ID: IDFromAddr(n), Addr: n})
+					}
+				}
+			}(c.Addr)
+		}
+		wg.Wait()
+
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.Addr] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+		sortByDistance(shortlist, target)
+		if len(shortlist) > BucketSize {
+			shortlist = shortlist[:BucketSize]
+		}
+	}
+
+	providers := make([]string, 0, len(providerSet))
+	for p := range providerSet {
+		providers = append(providers, p)
+	}
+	return shortlist, providers
+}
+
+// call performs one RPC against a remote node
+func (d *DHT) call(addr, method string, target NodeID, out interface{}) error {
+	client := d.client()
+	if client == nil {
+		return fmt.Errorf("client not ready")
+	}
+	body, _ := json.Marshal(rpcRequest{Sender: d.Self(), Target: target.String()})
+	req, err := http.NewRequest("POST", "http://"+addr+"/api/dht/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Onivex-Version", config.ProtocolVersion)
+	req.Header.Set("Content-Type", "application/json")
+	// The body names us anyway, so signing gives nothing away; it proves
+	// PROVIDE records and keeps us out of the peer's anonymous rate limit
+	if d.Sign != nil {
+		d.Sign(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", method, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (d *DHT) storeProvider(key NodeID, addr string) {
+	if addr == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	set, ok := d.providers[key]
+	if !ok {
+		set = make(map[string]time.Time)
+		d.providers[key] = set
+	}
+	set[addr] = time.Now().Add(ProviderTTL)
+}
+
+func (d *DHT) localProviders(key NodeID) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	list := []string{}
+	for addr, expires := range d.providers[key] {
+		if now.After(expires) {
+			delete(d.providers[key], addr)
+			continue
+		}
+		list = append(list, addr)
+	}
+	if len(d.providers[key]) == 0 {
+		delete(d.providers, key)
+	}
+	return list
+}
+
+func addrs(contacts []Contact) []string {
+	list := make([]string, 0, len(contacts))
+	for _, c := range contacts {
+		list = append(list, c.Addr)
+	}
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
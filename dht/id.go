@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// IDLength is the size of node IDs and content keys in bytes (256-bit keyspace)
+const IDLength = 32
+
+// NodeID is a point in the DHT keyspace. Node IDs are derived from onion
+// addresses and content keys are the SHA-256 of the shared file.
+type NodeID [IDLength]byte
+
+// IDFromAddr derives a node's ID from its onion address
+func IDFromAddr(onionAddr string) NodeID {
+	return NodeID(sha256.Sum256([]byte(strings.ToLower(onionAddr))))
+}
+
+// ParseID decodes a hex content hash (as found in FileMeta.Hash) into a key
+func ParseID(s string) (NodeID, error) {
+	var id NodeID
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return id, err
+	}
+	if len(raw) != IDLength {
+		return id, fmt.Errorf("invalid key length %d", len(raw))
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Xor returns the Kademlia distance between two IDs
+func (id NodeID) Xor(other NodeID) NodeID {
+	var d NodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// Less reports whether distance id is smaller than other
+func (id NodeID) Less(other NodeID) bool {
+	for i := range id {
+		if id[i] != other[i] {
+			return id[i] < other[i]
+		}
+	}
+	return false
+}
+
+// PrefixLen returns the number of leading zero bits, i.e. the bucket index
+// a distance falls into. Identical IDs return IDLength*8.
+func (id NodeID) PrefixLen() int {
+	for i, b := range id {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return IDLength * 8
+}
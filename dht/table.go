@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Contact is a DHT participant. The ID is always recomputed from Addr so a
+// peer cannot pick its own position in the keyspace.
+type Contact struct {
+	ID       NodeID    `json:"-"`
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// RoutingTable holds up to BucketSize contacts per distance prefix
+type RoutingTable struct {
+	mu      sync.RWMutex
+	self    NodeID
+	buckets [IDLength*8 + 1][]Contact
+}
+
+func NewRoutingTable(self NodeID) *RoutingTable {
+	return &RoutingTable{self: self}
+}
+
+// Update records that addr is alive. Known contacts move to the tail of
+// their bucket; new contacts are dropped when the bucket is already full,
+// favouring long-lived nodes as Kademlia does.
+func (rt *RoutingTable) Update(addr string) {
+	id := IDFromAddr(addr)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if id == rt.self {
+		return
+	}
+
+	idx := rt.self.Xor(id).PrefixLen()
+	bucket := rt.buckets[idx]
+	for i, c := range bucket {
+		if c.ID == id {
+			c.LastSeen = time.Now()
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			rt.buckets[idx] = append(bucket, c)
+			return
+		}
+	}
+	if len(bucket) < BucketSize {
+		rt.buckets[idx] = append(bucket, Contact{ID: id, Addr: addr, LastSeen: time.Now()})
+	}
+}
+
+// Remove evicts a contact that failed to answer an RPC
+func (rt *RoutingTable) Remove(addr string) {
+	id := IDFromAddr(addr)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	idx := rt.self.Xor(id).PrefixLen()
+	bucket := rt.buckets[idx]
+	for i, c := range bucket {
+		if c.ID == id {
+			rt.buckets[idx] = append(bucket[:i], bucket[i+1:]...)
+			return
+		}
+	}
+}
+
+// Rekey re-buckets every contact around a new self ID. Contacts that no
+// longer fit in a full bucket are dropped.
+func (rt *RoutingTable) Rekey(self NodeID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	old := rt.buckets
+	rt.self = self
+	rt.buckets = [IDLength*8 + 1][]Contact{}
+	for _, bucket := range old {
+		for _, c := range bucket {
+			if c.ID == self {
+				continue
+			}
+			idx := self.Xor(c.ID).PrefixLen()
+			if len(rt.buckets[idx]) < BucketSize {
+				rt.buckets[idx] = append(rt.buckets[idx], c)
+			}
+		}
+	}
+}
+
+// Closest returns up to n contacts sorted by distance to target
+func (rt *RoutingTable) Closest(target NodeID, n int) []Contact {
+	rt.mu.RLock()
+	all := []Contact{}
+	for _, bucket := range rt.buckets {
+		all = append(all, bucket...)
+	}
+	rt.mu.RUnlock()
+
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// Size returns the number of contacts in the table
+func (rt *RoutingTable) Size() int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	n := 0
+	for _, bucket := range rt.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+func sortByDistance(contacts []Contact, target NodeID) {
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].ID.Xor(target).Less(contacts[j].ID.Xor(target))
+	})
+}
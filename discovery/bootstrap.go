@@ -1,6 +1,7 @@
 package discovery
 
-// BootstrapPeers is the hardcoded list of reliable Seed Nodes.
+// BootstrapPeers is the built-in list of reliable Seed Nodes, used until
+// a signed seed manifest or the seeds setting says otherwise.
 var BootstrapPeers = []string{
     "ehl4v7acayroyl2znqvlomyyqk5qg6gb6y2zwtajfczyy2blqo4cdrad.onion",
 }
\ No newline at end of file
@@ -0,0 +1,267 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+)
+
+// FederationPath is where seeds serve their peer table to sibling seeds
+const FederationPath = "/api/federation/peers"
+
+// FederatedPeer is one entry in a seed's shared peer table
+type FederatedPeer struct {
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// FederationTable is the body served at FederationPath: the peers that
+// announced themselves to Seed directly. Mirrored entries are never passed
+// on, so attribution stays with the seed that actually saw the peer.
+type FederationTable struct {
+	Seed  string          `json:"seed"`
+	Peers []FederatedPeer `json:"peers"`
+}
+
+// SiblingStatus is what we know about one sibling seed
+type SiblingStatus struct {
+	Addr     string     `json:"addr"`
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	Peers    int        `json:"peers"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Federation mirrors the peer tables of sibling seeds. Requests between
+// siblings are signed (see SignRequest) and only siblings may pull.
+type Federation struct {
+	pm   *PeerManager
+	self func() string
+
+	// PeerTimeout drops mirrored peers the owning seed hasn't seen for
+	// this long, matching the seed's own cleanup
+	PeerTimeout time.Duration
+
+	mu       sync.RWMutex
+	siblings map[string]*SiblingStatus
+	mirrored map[string]map[string]time.Time // peer -> seed -> last seen
+}
+
+// NewFederation federates pm with the given sibling seeds. self returns our
+// own address; it is dropped from the sibling list.
+func NewFederation(pm *PeerManager, self func() string, siblings []string) *Federation {
+	f := &Federation{
+		pm:          pm,
+		self:        self,
+		PeerTimeout: 60 * time.Minute,
+		siblings:    make(map[string]*SiblingStatus),
+		mirrored:    make(map[string]map[string]time.Time),
+	}
+	for _, s := range siblings {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" && s != strings.ToLower(self()) {
+			f.siblings[s] = &SiblingStatus{Addr: s}
+		}
+	}
+	return f
+}
+
+// Siblings returns the sibling seeds' addresses
+func (f *Federation) Siblings() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	list := make([]string, 0, len(f.siblings))
+	for s := range f.siblings {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (f *Federation) isSibling(addr string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, ok := f.siblings[strings.ToLower(addr)]
+	return ok
+}
+
+// localTable is our own peer table, as shared with siblings
+func (f *Federation) localTable() FederationTable {
+	self := f.self()
+	table := FederationTable{Seed: self, Peers: []FederatedPeer{}}
+	cutoff := time.Now().Add(-f.PeerTimeout)
+	f.pm.mu.RLock()
+	defer f.pm.mu.RUnlock()
+	for addr, info := range f.pm.KnownPeers {
+		if addr == self || info.LastSeen.Before(cutoff) {
+			continue
+		}
+		table.Peers = append(table.Peers, FederatedPeer{Addr: addr, LastSeen: info.LastSeen})
+	}
+	return table
+}
+
+// Handler serves our peer table to signed requests from siblings
+func (f *Federation) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin, err := VerifyRequest(r, f.self())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !f.isSibling(origin) {
+			http.Error(w, "Not a federated seed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f.localTable())
+	}
+}
+
+// pull fetches one sibling's table and replaces its entries in the mirror
+func (f *Federation) pull(sibling string) error {
+	req, err := http.NewRequest("GET", "http://"+sibling+FederationPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Onivex-Version", config.ProtocolVersion)
+	// Siblings only answer seeds they can identify
+	SignRequest(req, f.pm.Transport)
+	resp, err := f.pm.GetTorClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	var table FederationTable
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return err
+	}
+	if !strings.EqualFold(table.Seed, sibling) {
+		return fmt.Errorf("table is attributed to %s", table.Seed)
+	}
+
+	self := strings.ToLower(f.self())
+	cutoff := time.Now().Add(-f.PeerTimeout)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for peer, seeds := range f.mirrored {
+		delete(seeds, sibling)
+		if len(seeds) == 0 {
+			delete(f.mirrored, peer)
+		}
+	}
+	count := 0
+	for _, p := range table.Peers {
+		addr := strings.ToLower(p.Addr)
+		if addr == "" || addr == self || p.LastSeen.Before(cutoff) {
+			continue
+		}
+		if f.mirrored[addr] == nil {
+			f.mirrored[addr] = make(map[string]time.Time)
+		}
+		f.mirrored[addr][sibling] = p.LastSeen
+		count++
+	}
+	f.siblings[sibling].Peers = count
+	return nil
+}
+
+// Sync pulls every sibling's table once
+func (f *Federation) Sync() {
+	var wg sync.WaitGroup
+	for _, s := range f.Siblings() {
+		wg.Add(1)
+		go func(sibling string) {
+			defer wg.Done()
+			err := f.pull(sibling)
+			f.mu.Lock()
+			st := f.siblings[sibling]
+			if err != nil {
+				st.Error = err.Error()
+				slog.Warn("🌐 Federation sync failed", "sibling", sibling, "err", err)
+			} else {
+				now := time.Now()
+				st.Error, st.LastSync = "", &now
+			}
+			f.mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Start syncs with the siblings every interval
+func (f *Federation) Start(interval time.Duration) {
+	go func() {
+		for {
+			f.Sync()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// Status reports each sibling's last sync, sorted by address
+func (f *Federation) Status() []SiblingStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	list := make([]SiblingStatus, 0, len(f.siblings))
+	for _, st := range f.siblings {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+// Attribution lists the sibling seeds that reported addr
+func (f *Federation) Attribution(addr string) []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	seeds := []string{}
+	for s := range f.mirrored[strings.ToLower(addr)] {
+		seeds = append(seeds, s)
+	}
+	sort.Strings(seeds)
+	return seeds
+}
+
+// Sample returns up to limit distinct peers drawn from our own table and
+// every sibling's, so a client learns peers no matter which seed it asked
+func (f *Federation) Sample(limit int) []string {
+	seen := make(map[string]bool)
+	all := []string{}
+	add := func(addr string) {
+		if key := strings.ToLower(addr); !seen[key] {
+			seen[key] = true
+			all = append(all, addr)
+		}
+	}
+	for _, p := range f.pm.GetPeers() {
+		add(p)
+	}
+	cutoff := time.Now().Add(-f.PeerTimeout)
+	f.mu.RLock()
+	for peer, seeds := range f.mirrored {
+		for _, lastSeen := range seeds {
+			if lastSeen.After(cutoff) {
+				add(peer)
+				break
+			}
+		}
+	}
+	f.mu.RUnlock()
+
+	rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all
+}
@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/network"
+)
+
+// FriendCardPrefix marks a pasteable friend card
+const FriendCardPrefix = "onivex-friend:"
+
+// FriendCard introduces a node: its onion and a display name, signed by
+// the onion's own key so nobody can hand out a card for someone else's
+// address.
+type FriendCard struct {
+	Addr      string    `json:"addr"`
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	Signature string    `json:"signature"`
+}
+
+func (c FriendCard) payload() []byte {
+	return []byte(strings.Join([]string{"onivex-friend-v1", strings.ToLower(c.Addr), c.Name, c.Created.UTC().Format(time.RFC3339)}, "\n"))
+}
+
+// NewFriendCard signs a card for our own address
+func NewFriendCard(t network.Transport, name string) (FriendCard, error) {
+	signer, ok := t.(network.Signer)
+	if !ok {
+		return FriendCard{}, fmt.Errorf("transport cannot sign")
+	}
+	card := FriendCard{Addr: t.Address(), Name: name, Created: time.Now().UTC().Truncate(time.Second)}
+	card.Signature = base64.StdEncoding.EncodeToString(signer.Sign(card.payload()))
+	return card, nil
+}
+
+// Verify checks the card was signed by the key behind its onion
+func (c FriendCard) Verify() error {
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return fmt.Errorf("bad signature encoding")
+	}
+	if !network.VerifyOnionSignature(c.Addr, c.payload(), sig) {
+		return fmt.Errorf("card for %s is not signed by that onion", c.Addr)
+	}
+	return nil
+}
+
+// Encode renders the card as a single pasteable line
+func (c FriendCard) Encode() string {
+	data, _ := json.Marshal(c)
+	return FriendCardPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseFriendCard reverses Encode and verifies the result
+func ParseFriendCard(s string) (FriendCard, error) {
+	var c FriendCard
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), FriendCardPrefix))
+	if err != nil {
+		return c, fmt.Errorf("not a friend card")
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("not a friend card")
+	}
+	return c, c.Verify()
+}
+
+// FriendList is the allowlist for friends-only mode, kept in data/friends.json
+type FriendList struct {
+	mu    sync.RWMutex
+	path  string
+	cards map[string]FriendCard
+}
+
+// LoadFriends reads data/friends.json; entries whose signatures no longer
+// verify are dropped with a warning.
+func LoadFriends(dataDir string) *FriendList {
+	fl := &FriendList{path: filepath.Join(dataDir, "friends.json"), cards: make(map[string]FriendCard)}
+	data, err := os.ReadFile(fl.path)
+	if err != nil {
+		return fl
+	}
+	var cards []FriendCard
+	if err := json.Unmarshal(data, &cards); err != nil {
+		slog.Warn("⚠️  Could not parse friends list", "file", fl.path, "err", err)
+		return fl
+	}
+	for _, c := range cards {
+		if err := c.Verify(); err != nil {
+			slog.Warn("⚠️  Ignoring friend entry", "err", err)
+			continue
+		}
+		fl.cards[strings.ToLower(c.Addr)] = c
+	}
+	return fl
+}
+
+func (fl *FriendList) save() error {
+	data, _ := json.MarshalIndent(fl.listLocked(), "", "  ")
+	return os.WriteFile(fl.path, data, 0600)
+}
+
+// Add verifies and stores a card, replacing any earlier one for that onion
+func (fl *FriendList) Add(c FriendCard) error {
+	if err := c.Verify(); err != nil {
+		return err
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.cards[strings.ToLower(c.Addr)] = c
+	return fl.save()
+}
+
+// Remove drops a friend
+func (fl *FriendList) Remove(addr string) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	delete(fl.cards, strings.ToLower(addr))
+	return fl.save()
+}
+
+// Has reports whether addr is a friend
+func (fl *FriendList) Has(addr string) bool {
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+	_, ok := fl.cards[strings.ToLower(addr)]
+	return ok
+}
+
+// List returns the friends sorted by name
+func (fl *FriendList) List() []FriendCard {
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+	return fl.listLocked()
+}
+
+func (fl *FriendList) listLocked() []FriendCard {
+	list := make([]FriendCard, 0, len(fl.cards))
+	for _, c := range fl.cards {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Addrs returns the friends' onions
+func (fl *FriendList) Addrs() []string {
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+	list := make([]string, 0, len(fl.cards))
+	for addr := range fl.cards {
+		list = append(list, addr)
+	}
+	return list
+}
+
+// allowed reports whether we may talk to addr: anyone normally, only
+// friends (and ourselves) in friends-only mode.
+func (pm *PeerManager) allowed(addr string) bool {
+	if !pm.FriendsOnly || pm.Friends == nil {
+		return true
+	}
+	return pm.Friends.Has(addr) || strings.EqualFold(addr, pm.Transport.Address())
+}
+
+// FriendsMiddleware refuses unsigned requests and requests from strangers
+// when the node runs friends-only. self returns our current address.
+func (pm *PeerManager) FriendsMiddleware(self func() string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !pm.FriendsOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin, err := VerifyRequest(r, self())
+		if err != nil || !pm.allowed(origin) {
+			http.Error(w, "Friends only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
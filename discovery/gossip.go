@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"onivex/bloom"
+	"onivex/config"
+	"onivex/filesystem"
+)
+
+// DigestHeader carries the responder's own filter digest on /api/peers and
+// /api/filter responses.
+const DigestHeader = "X-Onivex-Filter-Digest"
+
+// GossipResponse is the /api/peers?digests=1 body. Plain /api/peers keeps
+// returning a bare []string for older clients.
+type GossipResponse struct {
+	FilterDigest string   `json:"filter_digest"`
+	Peers        []string `json:"peers"`
+}
+
+// LocalFilter returns the filter for this node's own share. It is only
+// rebuilt when the shared file names or the bloom settings change; callers
+// must not modify it.
+func (pm *PeerManager) LocalFilter() *bloom.Filter {
+	names, _ := pm.Share.FileNames()
+	cfg := config.Current().Search
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %g\x00", cfg.BloomSize, cfg.BloomFPRate)
+	for _, name := range names {
+		h.Write([]byte(name + "\x00"))
+	}
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+
+	pm.filterMu.Lock()
+	defer pm.filterMu.Unlock()
+	if pm.localFilter == nil || pm.filterKey != key {
+		pm.localFilter = filterNames(names)
+		pm.filterKey = key
+	}
+	return pm.localFilter
+}
+
+// BuildFilter indexes the names and name tokens of the given files
+func BuildFilter(files []filesystem.FileMeta) *bloom.Filter {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return filterNames(names)
+}
+
+func filterNames(names []string) *bloom.Filter {
+	cfg := config.Current().Search
+	filter := bloom.New(uint(cfg.BloomSize), cfg.BloomFPRate)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		filter.Add([]byte(name))
+		tokens := strings.FieldsFunc(name, func(r rune) bool {
+			return r == '.' || r == ' ' || r == '_' || r == '-'
+		})
+		for _, token := range tokens {
+			if len(token) > 0 {
+				filter.Add([]byte(token))
+			}
+		}
+	}
+	return filter
+}
+
+// NoteAdvertisedDigest records the digest a peer says its filter currently
+// has, so the next Sync knows whether to re-pull it.
+func (pm *PeerManager) NoteAdvertisedDigest(onionAddr, digest string) {
+	if digest == "" {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if info, exists := pm.KnownPeers[onionAddr]; exists {
+		info.AdvertisedDigest = digest
+		pm.KnownPeers[onionAddr] = info
+	}
+}
+
+// needsFilter reports whether our cached filter for a peer is missing or
+// differs from the digest the peer last advertised.
+func (pm *PeerManager) needsFilter(onionAddr string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	info, exists := pm.KnownPeers[onionAddr]
+	if !exists || info.Filter == nil {
+		return true
+	}
+	return info.AdvertisedDigest == "" || info.AdvertisedDigest != info.FilterDigest
+}
+
+// decodeGossip accepts both the digest-aware object and the legacy []string
+func decodeGossip(raw json.RawMessage) GossipResponse {
+	var g GossipResponse
+	if json.Unmarshal(raw, &g) == nil {
+		return g
+	}
+	json.Unmarshal(raw, &g.Peers)
+	return g
+}
@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"onivex/config"
+)
+
+// helloTTL is how long a handshake result is trusted before Sync redoes it
+const helloTTL = 1 * time.Hour
+
+// Hello is exchanged in both directions on POST /api/hello
+type Hello struct {
+	Addr         string   `json:"addr,omitempty"`
+	Version      string   `json:"version"`
+	MinVersion   string   `json:"min_version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// VersionError is the JSON body sent with 426 Upgrade Required
+type VersionError struct {
+	Error      string `json:"error"`
+	Version    string `json:"version"`
+	MinVersion string `json:"min_version"`
+}
+
+// VersionMiddleware stamps our version on every response and refuses peers
+// that announce a version below config.MinCompatibleVersion. Requests with no
+// version header (browsers, curl) are let through untouched.
+func VersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Onivex-Version", config.ProtocolVersion)
+
+		clientVer := r.Header.Get("X-Onivex-Version")
+		if clientVer != "" && !config.IsCompatible(clientVer) {
+			refuseVersion(w, clientVer)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func refuseVersion(w http.ResponseWriter, theirs string) {
+	slog.Warn("⚠️  Refusing peer on incompatible version", "version", theirs, "min", config.MinCompatibleVersion)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUpgradeRequired)
+	json.NewEncoder(w).Encode(VersionError{
+		Error:      "incompatible protocol version",
+		Version:    config.ProtocolVersion,
+		MinVersion: config.MinCompatibleVersion,
+	})
+}
+
+// HelloHandler answers /api/hello with our version and capabilities and
+// records the caller's in its PeerInfo.
+func (pm *PeerManager) HelloHandler(myAddr string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var theirs Hello
+			if err := json.NewDecoder(r.Body).Decode(&theirs); err == nil {
+				if !config.IsCompatible(theirs.Version) {
+					refuseVersion(w, theirs.Version)
+					return
+				}
+				if addr := strings.ToLower(theirs.Addr); addr != "" && addr != myAddr {
+					pm.AddPeer(addr)
+					pm.recordHello(addr, theirs)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pm.localHello(myAddr))
+	}
+}
+
+func (pm *PeerManager) localHello(myAddr string) Hello {
+	return Hello{
+		Addr:         myAddr,
+		Version:      config.ProtocolVersion,
+		MinVersion:   config.MinCompatibleVersion,
+		Capabilities: pm.Capabilities,
+	}
+}
+
+// Handshake exchanges hellos with a peer. Peers predating /api/hello (404)
+// are recorded with their header version and no capabilities.
+func (pm *PeerManager) Handshake(targetPeer string, myAddr string) error {
+	body, _ := json.Marshal(pm.localHello(myAddr))
+	resp, err := pm.sendRequest("POST", "http://"+targetPeer+"/api/hello", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var theirs Hello
+		if err := json.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+			return fmt.Errorf("bad hello from %s: %w", targetPeer, err)
+		}
+		pm.recordHello(targetPeer, theirs)
+		pm.markReached(targetPeer)
+	case http.StatusNotFound:
+		pm.recordHello(targetPeer, Hello{Version: resp.Header.Get("X-Onivex-Version")})
+	case http.StatusUpgradeRequired:
+		var refusal VersionError
+		json.NewDecoder(resp.Body).Decode(&refusal)
+		pm.recordHello(targetPeer, Hello{Version: refusal.Version})
+		pm.markIncompatible(targetPeer)
+		return fmt.Errorf("%s requires protocol >= %s", targetPeer, refusal.MinVersion)
+	default:
+		return fmt.Errorf("hello to %s returned %d", targetPeer, resp.StatusCode)
+	}
+
+	if !pm.IsCompatible(targetPeer) {
+		return fmt.Errorf("%s speaks incompatible version", targetPeer)
+	}
+	if pm.DHT != nil && !pm.Supports(targetPeer, config.CapDHT) {
+		pm.DHT.Table.Remove(targetPeer)
+	}
+	return nil
+}
+
+func (pm *PeerManager) recordHello(onionAddr string, h Hello) {
+	if h.Version == "" {
+		h.Version = "1.0.0"
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if info, exists := pm.KnownPeers[onionAddr]; exists {
+		info.Version = h.Version
+		info.Capabilities = h.Capabilities
+		info.HelloAt = time.Now()
+		info.Incompatible = !config.IsCompatible(h.Version)
+		pm.KnownPeers[onionAddr] = info
+	}
+}
+
+func (pm *PeerManager) markReached(onionAddr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if info, exists := pm.KnownPeers[onionAddr]; exists {
+		info.ReachedAt = time.Now()
+		pm.KnownPeers[onionAddr] = info
+	}
+}
+
+func (pm *PeerManager) markIncompatible(onionAddr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if info, exists := pm.KnownPeers[onionAddr]; exists {
+		info.Incompatible = true
+		pm.KnownPeers[onionAddr] = info
+	}
+}
+
+// needsHandshake reports whether we have no recent hello for a peer
+func (pm *PeerManager) needsHandshake(onionAddr string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	info := pm.KnownPeers[onionAddr]
+	return time.Since(info.HelloAt) > helloTTL
+}
+
+// IsCompatible is false only for peers that we know speak a too-old version
+func (pm *PeerManager) IsCompatible(onionAddr string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return !pm.KnownPeers[onionAddr].Incompatible
+}
+
+// Supports reports whether a peer advertised a capability in its hello.
+// Callers use it to pick the best protocol variant per peer.
+func (pm *PeerManager) Supports(onionAddr, capability string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	for _, c := range pm.KnownPeers[onionAddr].Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+)
+
+// anonKey is the bucket every unsigned or untrusted request draws from.
+// Tor hides the circuit a request came in on (behind an onion service the
+// remote address is tor's own socket), so these can't be told apart.
+const anonKey = "anon"
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter enforces the limits settings on the onion-facing API: a body
+// size cap on every request, a token bucket per trusted signed origin (and
+// one shared by everyone else) on /api/, and a cap on concurrent searches.
+// Limits are read live, so changes in settings apply immediately.
+type Limiter struct {
+	self func() string
+
+	// Trusted reports whether a signed origin gets a bucket of its own.
+	// Onion keys cost nothing to make, so a signature alone doesn't: a
+	// flooder could sign each request with a fresh key.
+	Trusted func(origin string) bool
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	searches  int
+}
+
+// NewLimiter builds a limiter; self returns our address, against which
+// request signatures are checked
+func NewLimiter(self func() string) *Limiter {
+	return &Limiter{self: self, buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// take spends one token from key's bucket. When the bucket is empty it
+// returns how long until the next token.
+func (l *Limiter) take(key string, perMinute, burst int, now time.Time) time.Duration {
+	rate := float64(perMinute) / 60
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		// A bucket idle long enough to refill completely carries no state
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*rate >= float64(burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// acquireSearch claims a search slot, or reports that all are busy
+func (l *Limiter) acquireSearch(max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.searches >= max {
+		return false
+	}
+	l.searches++
+	return true
+}
+
+func (l *Limiter) releaseSearch() {
+	l.mu.Lock()
+	l.searches--
+	l.mu.Unlock()
+}
+
+// isSearch reports whether path runs a search over our share
+func isSearch(path string) bool {
+	return path == "/api/search" || path == "/api/query"
+}
+
+// Middleware applies the limits in front of next
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lim := config.Current().Limits
+		r.Body = http.MaxBytesReader(w, r.Body, int64(lim.MaxBodyBytes))
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Trusted peers never touch the anonymous bucket, so a flood of
+		// anonymous requests can't starve them
+		now := time.Now()
+		wait := time.Duration(0)
+		if origin, err := VerifyRequest(r, l.self()); err == nil && l.Trusted != nil && l.Trusted(origin) {
+			wait = l.take("origin:"+strings.ToLower(origin), lim.RequestsPerMinute, lim.Burst, now)
+		} else {
+			wait = l.take(anonKey, lim.AnonRequestsPerMinute, lim.AnonRequestsPerMinute, now)
+		}
+		if wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		if isSearch(r.URL.Path) {
+			if !l.acquireSearch(lim.MaxConcurrentSearches) {
+				tooManyRequests(w, time.Second)
+				return
+			}
+			defer l.releaseSearch()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Trusted reports whether addr is a friend or a peer we reached with a
+// handshake of our own, for Limiter.Trusted. Announcing an address is free,
+// answering a hello at it means running that onion service.
+func (pm *PeerManager) Trusted(addr string) bool {
+	addr = strings.ToLower(addr)
+	if pm.Friends != nil && pm.Friends.Has(addr) {
+		return true
+	}
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return !pm.KnownPeers[addr].ReachedAt.IsZero()
+}
+
+// tooManyRequests answers 429 with a Retry-After in whole seconds
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
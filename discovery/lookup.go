@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"onivex/config"
+	"onivex/dht"
+	"onivex/filesystem"
+)
+
+// IsContentHash reports whether a search query is a hex SHA-256 content hash
+// that should be resolved through the DHT instead of keyword search.
+func IsContentHash(query string) bool {
+	_, err := dht.ParseID(query)
+	return err == nil
+}
+
+// NewDHT creates the DHT node for pm. PROVIDE requests are signed with our
+// onion key and checked on arrival.
+func (pm *PeerManager) NewDHT(self string) *dht.DHT {
+	d := dht.New(self, pm.GetTorClient)
+	d.Sign = func(req *http.Request) { SignRequest(req, pm.Transport) }
+	d.Verify = VerifyRequest
+	return d
+}
+
+// ProvideShares announces every shared file's hash to the DHT
+func (pm *PeerManager) ProvideShares() {
+	if pm.DHT == nil {
+		return
+	}
+	files, _ := pm.Share.GetFileList()
+	count := 0
+	for _, f := range files {
+		key, err := dht.ParseID(f.Hash)
+		if err != nil {
+			continue
+		}
+		pm.DHT.Provide(key)
+		count++
+	}
+	slog.Info("📣 Published provider records to the DHT", "records", count)
+}
+
+// SearchHash resolves a content hash to providers via the DHT and asks each
+// provider for the file's metadata.
+func (pm *PeerManager) SearchHash(hash string, myAddr string) []SearchResult {
+	results := []SearchResult{}
+	key, err := dht.ParseID(hash)
+	if err != nil || pm.DHT == nil {
+		return results
+	}
+
+	providers := pm.DHT.FindProviders(key)
+	slog.Info("🧭 DHT lookup", "query", hash, "providers", len(providers))
+
+	client := pm.GetTorClient()
+	if client == nil {
+		return results
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range providers {
+		if p == myAddr {
+			continue
+		}
+		wg.Add(1)
+		go func(peerID string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://%s/api/hash?h=%s", peerID, url.QueryEscape(hash)), nil)
+			req.Header.Set("X-Onivex-Version", config.ProtocolVersion)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var meta filesystem.FileMeta
+			if json.NewDecoder(resp.Body).Decode(&meta) == nil && meta.Hash == hash {
+				mu.Lock()
+				results = append(results, SearchResult{
+					PeerID: peerID,
+					Files:  []filesystem.FileMeta{meta},
+					Source: "dht",
+				})
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return results
+}
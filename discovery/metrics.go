@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"time"
+
+	"onivex/metrics"
+)
+
+// alivePeerWindow is how recently a peer must have been seen to count as
+// alive, matching the seeds' cleanup timeout
+const alivePeerWindow = time.Hour
+
+var (
+	syncs = metrics.NewCounter("onivex_peer_syncs_total",
+		"Peer syncs by result (ok or error)", "result")
+	searchFanout = metrics.NewHistogram("onivex_search_fanout_peers",
+		"Peers dialed per network search, after bloom filtering",
+		[]float64{0, 1, 2, 5, 10, 20, 50, 100, 200})
+	searchPeerLatency = metrics.NewHistogram("onivex_search_peer_latency_seconds",
+		"Time for one peer to answer a search, by outcome (hit, miss or error)",
+		[]float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60}, "outcome")
+
+	// ForwardedQueries counts /api/query traffic: "sent" by ForwardSearch,
+	// "received" by the node's handler
+	ForwardedQueries = metrics.NewCounter("onivex_forwarded_queries_total",
+		"Forwarded search queries by direction (sent or received)", "direction")
+)
+
+// RegisterMetrics adds scrape-time gauges for this peer manager's peers,
+// bloom filter and downloads to the default registry
+func (pm *PeerManager) RegisterMetrics() {
+	r := metrics.Default
+	r.GaugeFunc("onivex_known_peers", "Peers in the peer table", func() float64 {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		return float64(len(pm.KnownPeers))
+	})
+	r.GaugeFunc("onivex_alive_peers", "Peers seen in the last hour", func() float64 {
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		n := 0
+		for _, info := range pm.KnownPeers {
+			if time.Since(info.LastSeen) < alivePeerWindow {
+				n++
+			}
+		}
+		return float64(n)
+	})
+	// LocalFilter is cached, so both bloom gauges read the same instance
+	r.GaugeFunc("onivex_bloom_fill_ratio", "Fraction of bits set in our share's bloom filter", func() float64 {
+		return pm.LocalFilter().FillRatio()
+	})
+	r.GaugeFunc("onivex_bloom_estimated_fp_rate", "Estimated false positive rate of our share's bloom filter", func() float64 {
+		return pm.LocalFilter().EstimatedFPRate()
+	})
+	r.CounterFunc("onivex_downloaded_bytes_total", "Bytes read from peers over the transport", func() float64 {
+		return float64(pm.Downloads.Total())
+	})
+}
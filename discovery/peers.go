@@ -2,9 +2,9 @@ package discovery
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,24 +15,65 @@ import (
 
 	"onivex/bloom"
 	"onivex/config" // <--- IMPORTED
+	"onivex/dht"
 	"onivex/filesystem"
-
-	"github.com/cretz/bine/tor"
+	"onivex/network"
+	"onivex/transfer"
 )
 
 type PeerInfo struct {
 	LastSeen time.Time     `json:"last_seen"`
 	Filter   *bloom.Filter `json:"filter"`
+
+	// FilterDigest is the digest of Filter; AdvertisedDigest is the latest
+	// digest the peer gossiped. A mismatch means our copy is stale.
+	FilterDigest     string `json:"filter_digest,omitempty"`
+	AdvertisedDigest string `json:"advertised_digest,omitempty"`
+
+	// Filled in by the /api/hello handshake
+	Version      string    `json:"version,omitempty"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	HelloAt      time.Time `json:"hello_at,omitempty"`
+
+	// ReachedAt is when a hello we sent to the peer's onion was answered
+	ReachedAt time.Time `json:"reached_at,omitempty"`
+	Incompatible bool      `json:"incompatible,omitempty"`
 }
 
 type PeerManager struct {
 	mu         sync.RWMutex
 	KnownPeers map[string]PeerInfo
-	Tor        *tor.Tor
+	Transport  network.Transport
 	DataDir    string
+	DHT        *dht.DHT
+	Share      *filesystem.Share
+
+	// Seeds are synced on every Bootstrap and skipped by searches
+	Seeds *SeedList
+
+	// Capabilities we advertise in /api/hello
+	Capabilities []string
+
+	// Friends is the allowlist; with FriendsOnly set we only talk to (and
+	// accept requests from) friends and keep our peer table to ourselves
+	Friends     *FriendList
+	FriendsOnly bool
+
+	clientMu      sync.Mutex
+	torClient     *http.Client
+	clientTimeout time.Duration
 
-	torClient  *http.Client
-	clientInit sync.Once
+	// Downloads paces every response we read, following the downloads
+	// settings
+	Downloads *transfer.Limiter
+
+	// pow is the stamp difficulty each peer last asked us for
+	pow powCache
+
+	// localFilter caches LocalFilter; filterKey hashes what it was built from
+	filterMu    sync.Mutex
+	localFilter *bloom.Filter
+	filterKey   [32]byte
 }
 
 type SearchResult struct {
@@ -41,59 +82,97 @@ type SearchResult struct {
 	Source string                `json:"source"`
 }
 
-func NewPeerManager(t *tor.Tor) *PeerManager {
+func NewPeerManager(t network.Transport) *PeerManager {
 	cwd, _ := os.Getwd()
-	dataDir := filepath.Join(cwd, "data")
+	return NewPeerManagerIn(t, filepath.Join(cwd, "data"), filesystem.Default)
+}
+
+// NewPeerManagerIn is NewPeerManager with an explicit data dir and share,
+// so several nodes can live in one process (see the simulation package).
+func NewPeerManagerIn(t network.Transport, dataDir string, share *filesystem.Share) *PeerManager {
 	os.MkdirAll(dataDir, 0700)
 
 	pm := &PeerManager{
-		KnownPeers: make(map[string]PeerInfo),
-		Tor:        t,
-		DataDir:    dataDir,
+		KnownPeers:   make(map[string]PeerInfo),
+		Transport:    t,
+		DataDir:      dataDir,
+		Share:        share,
+		Seeds:        LoadSeeds(dataDir),
+		Capabilities: config.Capabilities,
+		Friends:      LoadFriends(dataDir),
+		Downloads:    transfer.NewDownloadLimiter(),
 	}
 	pm.LoadPeers()
 	return pm
 }
 
+// GetTorClient returns the shared HTTP client that dials through the node's
+// transport (Tor in production, loopback in tests). It is rebuilt when the
+// client_timeout setting changes.
 func (pm *PeerManager) GetTorClient() *http.Client {
-	pm.clientInit.Do(func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		defer cancel()
-
-		fmt.Println("🔌 Initializing Shared Tor Client...")
+	timeout := config.Current().Network.ClientTimeout.D()
+	pm.clientMu.Lock()
+	defer pm.clientMu.Unlock()
+	if pm.torClient == nil || pm.clientTimeout != timeout {
+		if pm.torClient == nil { slog.Info("🔌 Initializing shared Tor client") }
+		pm.torClient = pm.NewClient(timeout)
+		pm.clientTimeout = timeout
+	}
+	return pm.torClient
+}
 
-		dialer, err := pm.Tor.Dialer(ctx, nil)
-		if err != nil {
-			fmt.Printf("❌ Failed to create Tor Dialer: %v\n", err)
-			return
-		}
+// NewClient builds an HTTP client over the transport whose requests are
+// signed with our identity and, in friends-only mode, only go to friends.
+// Responses share the download rate limit.
+func (pm *PeerManager) NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &signingTransport{pm: pm, base: &transfer.Transport{Limiter: pm.Downloads, Base: &http.Transport{
+			DialContext:         pm.Transport.DialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 20,
+			IdleConnTimeout:     90 * time.Second,
+		}}},
+		Timeout: timeout,
+	}
+}
 
-		pm.torClient = &http.Client{
-			Transport: &http.Transport{
-				DialContext: dialer.DialContext,
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 20,
-				IdleConnTimeout:     90 * time.Second,
-			},
-			Timeout: 60 * time.Second,
-		}
-		fmt.Println("✅ Shared Tor Client Ready")
-	})
-	return pm.torClient
+// NewDownloadClient is NewClient for file transfers, which may take as
+// long as they need: it only gives up on a peer that sends no headers,
+// or no data, for idle.
+func (pm *PeerManager) NewDownloadClient(idle time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &signingTransport{pm: pm, base: &transfer.Transport{Limiter: pm.Downloads, Idle: idle, Base: &http.Transport{
+			DialContext:           pm.Transport.DialContext,
+			ResponseHeaderTimeout: idle,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   20,
+			IdleConnTimeout:       90 * time.Second,
+		}}},
+	}
 }
 
 func (pm *PeerManager) AddPeer(onionAddr string) {
+	onionAddr = strings.ToLower(onionAddr)
+	if onionAddr == "" || !pm.allowed(onionAddr) { return }
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
-	if onionAddr == "" { return }
 
 	info, exists := pm.KnownPeers[onionAddr]
 	if !exists {
-		fmt.Printf("🔭 New Peer Discovered: %s\n", onionAddr)
+		slog.Info("🔭 New peer discovered", "peer", onionAddr)
 		info = PeerInfo{}
 	}
 	info.LastSeen = time.Now()
 	pm.KnownPeers[onionAddr] = info
+	if pm.DHT != nil { pm.DHT.AddContact(onionAddr) }
+}
+
+// RemovePeer forgets a peer, e.g. our own address after a rotation
+func (pm *PeerManager) RemovePeer(onionAddr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.KnownPeers, onionAddr)
+	if pm.DHT != nil { pm.DHT.Table.Remove(onionAddr) }
 }
 
 func (pm *PeerManager) UpdatePeerFilter(onionAddr string, filter *bloom.Filter) {
@@ -101,6 +180,17 @@ func (pm *PeerManager) UpdatePeerFilter(onionAddr string, filter *bloom.Filter)
 	defer pm.mu.Unlock()
 	if info, exists := pm.KnownPeers[onionAddr]; exists {
 		info.Filter = filter
+		info.FilterDigest = filter.Digest()
+		info.LastSeen = time.Now()
+		pm.KnownPeers[onionAddr] = info
+	}
+}
+
+// markSeen refreshes LastSeen for a known peer that just answered us
+func (pm *PeerManager) markSeen(onionAddr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if info, exists := pm.KnownPeers[onionAddr]; exists {
 		info.LastSeen = time.Now()
 		pm.KnownPeers[onionAddr] = info
 	}
@@ -114,11 +204,21 @@ func (pm *PeerManager) GetPeers() []string {
 	return list
 }
 
+// PeerInfos copies the peer table
+func (pm *PeerManager) PeerInfos() map[string]PeerInfo {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	infos := make(map[string]PeerInfo, len(pm.KnownPeers))
+	for p, info := range pm.KnownPeers { infos[p] = info }
+	return infos
+}
+
 func (pm *PeerManager) GetRandomPeers(limit int) []string {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 	list := []string{}
 	for p := range pm.KnownPeers {
+		if !pm.allowed(p) { continue }
 		list = append(list, p)
 		if len(list) >= limit { break }
 	}
@@ -168,11 +268,33 @@ func (pm *PeerManager) StartCleanup(interval time.Duration, peerTimeout time.Dur
 	}()
 }
 
+// GossipPeers is what we hand out in /api/peers: nothing in friends-only
+// mode, so friends can't map out our other friends
+func (pm *PeerManager) GossipPeers(limit int) []string {
+	if pm.FriendsOnly { return []string{} }
+	return pm.GetRandomPeers(limit)
+}
+
+// Bootstrap syncs with every seed and up to 5 known peers in parallel and
+// returns once all of those syncs have finished. In friends-only mode it
+// syncs with every friend instead.
 func (pm *PeerManager) Bootstrap(myOnionAddr string) {
-	if len(BootstrapPeers) > 0 {
-		for _, seed := range BootstrapPeers {
-			if seed != myOnionAddr { go pm.Sync(seed, myOnionAddr) }
+	var wg sync.WaitGroup
+	start := func(peer string) {
+		wg.Add(1)
+		go func() { defer wg.Done(); pm.Sync(peer, myOnionAddr) }()
+	}
+
+	if pm.FriendsOnly {
+		for _, friend := range pm.Friends.Addrs() {
+			if friend != myOnionAddr { start(friend) }
 		}
+		wg.Wait()
+		return
+	}
+
+	for _, seed := range pm.Seeds.List() {
+		if seed != myOnionAddr { start(seed) }
 	}
 	pm.mu.RLock()
 	candidates := make([]string, 0, len(pm.KnownPeers))
@@ -182,8 +304,9 @@ func (pm *PeerManager) Bootstrap(myOnionAddr string) {
 	limit := 5
 	for i, peer := range candidates {
 		if i >= limit { break }
-		if peer != myOnionAddr { go pm.Sync(peer, myOnionAddr) }
+		if peer != myOnionAddr { start(peer) }
 	}
+	wg.Wait()
 }
 
 // Helper to send request with Version Header
@@ -198,30 +321,66 @@ func (pm *PeerManager) sendRequest(method, urlStr string, body []byte) (*http.Re
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	// Hellos and gossip name us in the body anyway; signing them lets the
+	// peer rate limit us apart from anonymous traffic
+	SignRequest(req, pm.Transport)
 
 	return client.Do(req)
 }
 
 func (pm *PeerManager) Sync(targetPeer string, myAddr string) {
-	payload := map[string]string{"addr": myAddr}
+	payload := map[string]string{"addr": myAddr, "filter_digest": pm.LocalFilter().Digest()}
 	jsonPayload, _ := json.Marshal(payload)
 
-	resp, err := pm.sendRequest("POST", "http://"+targetPeer+"/api/peers", jsonPayload)
+	if pm.needsHandshake(targetPeer) {
+		if err := pm.Handshake(targetPeer, myAddr); err != nil {
+			slog.Warn("🤝 Handshake failed", "peer", targetPeer, "err", err)
+		}
+	}
+	if !pm.IsCompatible(targetPeer) { return }
+
+	peersURL := "http://" + targetPeer + "/api/peers"
+	if pm.Supports(targetPeer, config.CapFilterDigests) { peersURL += "?digests=1" }
+
+	resp, err := pm.sendRequest("POST", peersURL, jsonPayload)
+	if err != nil || resp.StatusCode != http.StatusOK { syncs.Inc("error") } else { syncs.Inc("ok"); pm.markSeen(targetPeer) }
 	if err == nil {
-		var newPeers []string
-		if json.NewDecoder(resp.Body).Decode(&newPeers) == nil {
-			for _, p := range newPeers {
+		var raw json.RawMessage
+		if json.NewDecoder(resp.Body).Decode(&raw) == nil {
+			gossip := decodeGossip(raw)
+			if gossip.FilterDigest == "" { gossip.FilterDigest = resp.Header.Get(DigestHeader) }
+			pm.NoteAdvertisedDigest(targetPeer, gossip.FilterDigest)
+			for _, p := range gossip.Peers {
 				if p != myAddr { pm.AddPeer(p) }
 			}
 		}
 		resp.Body.Close()
 	}
 
+	// Only pull the full filter when the gossiped digest says it changed
+	if !pm.needsFilter(targetPeer) { return }
+
+	if pm.Supports(targetPeer, config.CapCompressedFilters) {
+		resp, err = pm.sendRequest("GET", "http://"+targetPeer+"/api/filter?format=packed", nil)
+		if err == nil {
+			var packed bloom.Packed
+			if json.NewDecoder(resp.Body).Decode(&packed) == nil {
+				if filter, err := packed.Unpack(); err == nil {
+					pm.UpdatePeerFilter(targetPeer, filter)
+					pm.NoteAdvertisedDigest(targetPeer, filter.Digest())
+				}
+			}
+			resp.Body.Close()
+		}
+		return
+	}
+
 	resp, err = pm.sendRequest("GET", "http://"+targetPeer+"/api/filter", nil)
 	if err == nil {
 		var filter bloom.Filter
 		if json.NewDecoder(resp.Body).Decode(&filter) == nil {
 			pm.UpdatePeerFilter(targetPeer, &filter)
+			pm.NoteAdvertisedDigest(targetPeer, filter.Digest())
 		}
 		resp.Body.Close()
 	}
@@ -232,7 +391,7 @@ func (pm *PeerManager) ForwardSearch(query string, ttl int, originAddr string) {
 
 	client := pm.GetTorClient()
 	if client == nil { return }
-	peers := pm.GetRandomPeers(3)
+	peers := pm.GetRandomPeers(config.Current().Search.ForwardFanout)
 
 	for _, p := range peers {
 		if p == originAddr { continue }
@@ -243,14 +402,15 @@ func (pm *PeerManager) ForwardSearch(query string, ttl int, originAddr string) {
 			req, _ := http.NewRequest("GET", urlStr, nil)
 			req.Header.Set("X-Onivex-Version", config.ProtocolVersion) // <--- UPDATED
 
-			client.Do(req)
+			ForwardedQueries.Inc("sent")
+			if resp, err := client.Do(req); err == nil { resp.Body.Close() }
 		}(p)
 	}
 }
 
 func (pm *PeerManager) SearchNetwork(query string, myAddr string) []SearchResult {
 	peers := pm.GetPeers()
-	fmt.Printf("🔍 Searching %d peers for '%s'...\n", len(peers), query)
+	slog.Info("🔍 Searching the network", "query", query, "peers", len(peers))
 
 	var results []SearchResult
 	var mu sync.Mutex
@@ -259,7 +419,7 @@ func (pm *PeerManager) SearchNetwork(query string, myAddr string) []SearchResult
 	pm.mu.RLock()
 	candidates := []string{}
 	for peerID, info := range pm.KnownPeers {
-		if peerID == myAddr { continue }
+		if peerID == myAddr || info.Incompatible || !pm.allowed(peerID) { continue }
 		if info.Filter != nil {
 			if info.Filter.Test([]byte(query)) { candidates = append(candidates, peerID) }
 		} else {
@@ -270,41 +430,50 @@ func (pm *PeerManager) SearchNetwork(query string, myAddr string) []SearchResult
 
 	client := pm.GetTorClient()
 	if client == nil {
-		fmt.Println("❌ Critical: Tor Client not ready")
+		slog.Error("❌ Tor client not ready")
 		return []SearchResult{}
 	}
 
-	maxWorkers := 10
-	sem := make(chan struct{}, maxWorkers)
+	sem := make(chan struct{}, config.Current().Search.MaxWorkers)
 	var wg sync.WaitGroup
 
 	isSeed := make(map[string]bool)
-	for _, s := range BootstrapPeers { isSeed[s] = true }
+	for _, s := range pm.Seeds.List() { isSeed[s] = true }
 
+	dial := []string{}
 	for _, p := range candidates {
-		if p == myAddr { continue }
-		if isSeed[p] { continue }
+		if p != myAddr && !isSeed[p] { dial = append(dial, p) }
+	}
+	searchFanout.Observe(float64(len(dial)))
 
+	for _, p := range dial {
 		wg.Add(1)
 		go func(peerID string) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			fmt.Printf("   ➡ Dialing %s...\n", peerID)
+			slog.Debug("➡ Dialing", "peer", peerID)
 
 			safeQuery := url.QueryEscape(query)
 
 			req, _ := http.NewRequest("GET", fmt.Sprintf("http://%s/api/search?q=%s", peerID, safeQuery), nil)
 			req.Header.Set("X-Onivex-Version", config.ProtocolVersion) // <--- UPDATED
 
+			start := time.Now()
+			outcome := "error"
+			defer func() { searchPeerLatency.Observe(time.Since(start).Seconds(), outcome) }()
+
 			resp, err := client.Do(req)
 			if err != nil { return }
 			defer resp.Body.Close()
 
 			var remoteFiles []filesystem.FileMeta
-			if err := json.NewDecoder(resp.Body).Decode(&remoteFiles); err == nil && len(remoteFiles) > 0 {
-				fmt.Printf("   ✅ HIT: Found %d files on %s\n", len(remoteFiles), peerID)
+			err = json.NewDecoder(resp.Body).Decode(&remoteFiles)
+			if err == nil && len(remoteFiles) == 0 { outcome = "miss" }
+			if err == nil && len(remoteFiles) > 0 {
+				outcome = "hit"
+				slog.Info("✅ Search hit", "peer", peerID, "files", len(remoteFiles))
 				mu.Lock()
 				results = append(results, SearchResult{
 					PeerID: peerID,
@@ -319,7 +488,7 @@ func (pm *PeerManager) SearchNetwork(query string, myAddr string) []SearchResult
 	wg.Wait()
 
 	if len(results) == 0 {
-		go pm.ForwardSearch(query, 2, myAddr)
+		go pm.ForwardSearch(query, config.Current().Search.ForwardTTL, myAddr)
 	}
 
 	return results
@@ -0,0 +1,242 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+)
+
+// Hashcash-style stamps make announcements and forwarded queries cost CPU,
+// since onion services can't tell one flooding client from many.
+const (
+	// PoWHeader carries "<unix-ts>:<nonce>" on a stamped request
+	PoWHeader = "X-Onivex-Pow"
+	// PoWDifficultyHeader is on every response: the bits we currently demand
+	PoWDifficultyHeader = "X-Onivex-Pow-Difficulty"
+
+	// powAutoFloor is the least difficulty Auto demands once under load
+	powAutoFloor = 16
+)
+
+// powProtected reports whether a request must carry a stamp: announcements
+// (POST /api/peers) and forwarded queries
+func powProtected(method, path string) bool {
+	return (method == http.MethodPost && path == "/api/peers") || path == "/api/query"
+}
+
+// powDigest hashes a stamp. It binds the method, host, path, body and time,
+// so a stamp can't be moved to another request or node.
+func powDigest(method, host, uri string, body []byte, ts, nonce string) [32]byte {
+	bodySum := sha256.Sum256(body)
+	return sha256.Sum256([]byte(strings.Join([]string{"onivex-pow-v1", method, strings.ToLower(host), uri,
+		hex.EncodeToString(bodySum[:]), ts, nonce}, "\n")))
+}
+
+func leadingZeros(sum [32]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// MintStamp searches for a nonce giving at least difficulty leading zero
+// bits and returns the PoWHeader value
+func MintStamp(method, host, uri string, body []byte, difficulty int) string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	for nonce := uint64(0); ; nonce++ {
+		n := strconv.FormatUint(nonce, 36)
+		if leadingZeros(powDigest(method, host, uri, body, ts, n)) >= difficulty {
+			return ts + ":" + n
+		}
+	}
+}
+
+// PoWGuard checks stamps on protected requests before any handler sees
+// them, and tracks how busy those endpoints are for Auto difficulty.
+type PoWGuard struct {
+	mu        sync.Mutex
+	window    time.Time // start of the current minute
+	count     int       // protected requests admitted this minute
+	prevCount int       // and in the minute before
+	spent     map[[32]byte]time.Time
+	lastSweep time.Time
+}
+
+func NewPoWGuard() *PoWGuard {
+	return &PoWGuard{window: time.Now().Truncate(time.Minute), spent: make(map[[32]byte]time.Time), lastSweep: time.Now()}
+}
+
+// rateLocked estimates protected requests per minute over a sliding window
+func (g *PoWGuard) rateLocked(now time.Time) float64 {
+	if minute := now.Truncate(time.Minute); !minute.Equal(g.window) {
+		if minute.Sub(g.window) == time.Minute {
+			g.prevCount = g.count
+		} else {
+			g.prevCount = 0
+		}
+		g.window, g.count = minute, 0
+	}
+	frac := now.Sub(g.window).Seconds() / 60
+	return float64(g.prevCount)*(1-frac) + float64(g.count)
+}
+
+// Difficulty is the number of bits we demand right now
+func (g *PoWGuard) Difficulty() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.difficultyLocked(time.Now())
+}
+
+func (g *PoWGuard) difficultyLocked(now time.Time) int {
+	s := config.Current().PoW
+	d := s.Difficulty
+	if rate := g.rateLocked(now); s.Auto && rate > float64(s.LoadThreshold) {
+		auto := powAutoFloor + int(math.Log2(rate/float64(s.LoadThreshold)))
+		if auto > d {
+			d = auto
+		}
+	}
+	if d > s.MaxDifficulty {
+		d = s.MaxDifficulty
+	}
+	return d
+}
+
+// spendLocked records a stamp, reporting false if it was used before
+func (g *PoWGuard) spendLocked(sum [32]byte, now time.Time) bool {
+	if now.Sub(g.lastSweep) > time.Minute {
+		for k, t := range g.spent {
+			if now.Sub(t) > 2*signatureSkew {
+				delete(g.spent, k)
+			}
+		}
+		g.lastSweep = now
+	}
+	if _, ok := g.spent[sum]; ok {
+		return false
+	}
+	g.spent[sum] = now
+	return true
+}
+
+// check validates r's stamp against difficulty. The body is read and put
+// back for the next handler.
+func (g *PoWGuard) check(r *http.Request, difficulty int, now time.Time) error {
+	ts, nonce, ok := strings.Cut(r.Header.Get(PoWHeader), ":")
+	if !ok {
+		return fmt.Errorf("proof of work required (%d bits)", difficulty)
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad stamp timestamp")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > signatureSkew || skew < -signatureSkew {
+		return fmt.Errorf("stamp expired")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := powDigest(r.Method, r.Host, r.URL.RequestURI(), body, ts, nonce)
+	if leadingZeros(sum) < difficulty {
+		return fmt.Errorf("stamp is below %d bits", difficulty)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.spendLocked(sum, now) {
+		return fmt.Errorf("stamp already used")
+	}
+	return nil
+}
+
+// Middleware demands stamps on protected requests. Unstamped or weak
+// requests get 428 with the difficulty to retry at.
+func (g *PoWGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		g.mu.Lock()
+		protected := powProtected(r.Method, r.URL.Path)
+		difficulty := g.difficultyLocked(now)
+		g.mu.Unlock()
+
+		w.Header().Set(PoWDifficultyHeader, strconv.Itoa(difficulty))
+		if protected && difficulty > 0 {
+			if err := g.check(r, difficulty, now); err != nil {
+				http.Error(w, err.Error(), http.StatusPreconditionRequired)
+				return
+			}
+		}
+		if protected {
+			// Only admitted requests count, so a flood of free unstamped
+			// ones can't push the difficulty up for everyone else
+			g.mu.Lock()
+			g.rateLocked(now)
+			g.count++
+			g.mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// powCache remembers the difficulty each peer last asked for
+type powCache struct {
+	mu    sync.Mutex
+	needs map[string]int
+}
+
+func (c *powCache) get(host string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.needs[strings.ToLower(host)]
+}
+
+func (c *powCache) note(host, header string) {
+	d, err := strconv.Atoi(header)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.needs == nil {
+		c.needs = make(map[string]int)
+	}
+	c.needs[strings.ToLower(host)] = d
+}
+
+// stamp mints a stamp of the given difficulty onto req, resetting its body
+// from GetBody
+func (c *powCache) stamp(req *http.Request, difficulty int) error {
+	var body []byte
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	req.Header.Set(PoWHeader, MintStamp(req.Method, req.URL.Host, req.URL.RequestURI(), body, difficulty))
+	return nil
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHost = "peer.onion"
+
+func stampedRequest(path, body, stamp string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://"+testHost+path, strings.NewReader(body))
+	if stamp != "" {
+		r.Header.Set(PoWHeader, stamp)
+	}
+	return r
+}
+
+func TestPoWCheckAcceptsAStampOnce(t *testing.T) {
+	g := NewPoWGuard()
+	body := `{"addr":"x"}`
+	stamp := MintStamp(http.MethodPost, testHost, "/api/peers", []byte(body), 8)
+
+	r := stampedRequest("/api/peers", body, stamp)
+	if err := g.check(r, 8, time.Now()); err != nil {
+		t.Fatalf("fresh stamp: %v", err)
+	}
+	if got, _ := io.ReadAll(r.Body); string(got) != body {
+		t.Errorf("body after check: %q, want %q", got, body)
+	}
+
+	if err := g.check(stampedRequest("/api/peers", body, stamp), 8, time.Now()); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("replayed stamp: got %v, want already used", err)
+	}
+}
+
+func TestPoWCheckRejects(t *testing.T) {
+	now := time.Now()
+	body := `{"addr":"x"}`
+	stamp := MintStamp(http.MethodPost, testHost, "/api/peers", []byte(body), 16)
+	old := strconv.FormatInt(now.Add(-2*signatureSkew).Unix(), 10) + ":0"
+	future := strconv.FormatInt(now.Add(2*signatureSkew).Unix(), 10) + ":0"
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want string
+	}{
+		{"missing", stampedRequest("/api/peers", body, ""), "required"},
+		{"no nonce", stampedRequest("/api/peers", body, "12345"), "required"},
+		{"bad timestamp", stampedRequest("/api/peers", body, "soon:0"), "timestamp"},
+		{"expired", stampedRequest("/api/peers", body, old), "expired"},
+		{"from the future", stampedRequest("/api/peers", body, future), "expired"},
+		{"other path", stampedRequest("/api/query", body, stamp), "below"},
+		{"other body", stampedRequest("/api/peers", `{"addr":"y"}`, stamp), "below"},
+		{"too weak", stampedRequest("/api/peers", body, stamp), "below"},
+	}
+	for _, tt := range tests {
+		// A stamp moved to another request hashes to a random digest,
+		// which meets 16 bits only once in 65536 tries
+		difficulty := 16
+		if tt.name == "too weak" {
+			difficulty = 48
+		}
+		err := NewPoWGuard().check(tt.r, difficulty, now)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error mentioning %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPoWSpendForgetsOnlyStaleStamps(t *testing.T) {
+	g := NewPoWGuard()
+	start := time.Now()
+	a, b := [32]byte{1}, [32]byte{2}
+
+	if !g.spendLocked(a, start) {
+		t.Fatal("first spend of a refused")
+	}
+	if g.spendLocked(a, start.Add(time.Second)) {
+		t.Fatal("second spend of a accepted")
+	}
+
+	// Within the skew window a spent stamp stays spent across sweeps
+	mid := start.Add(signatureSkew)
+	if !g.spendLocked(b, mid) {
+		t.Fatal("first spend of b refused")
+	}
+	if g.spendLocked(a, mid) {
+		t.Error("a forgotten while it could still pass the timestamp check")
+	}
+
+	// Past twice the skew the stamp can no longer pass check, so it's swept
+	late := start.Add(2*signatureSkew + 2*time.Minute)
+	if !g.spendLocked([32]byte{3}, late) {
+		t.Fatal("spend after sweep refused")
+	}
+	if _, ok := g.spent[a]; ok {
+		t.Error("stale stamp a not swept")
+	}
+	if _, ok := g.spent[b]; !ok {
+		t.Error("b swept before it went stale")
+	}
+}
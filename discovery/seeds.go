@@ -0,0 +1,296 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+	"onivex/network"
+)
+
+// MaintainerKeys are the base64 ed25519 public keys whose signature makes a
+// seed manifest trusted. Keys from the seed_manifest_keys setting are
+// trusted as well, which is how private meshes run their own seeds. No
+// maintainer key ships yet, so manifests are opt-in: until a node lists a
+// key in seed_manifest_keys it never fetches one.
+var MaintainerKeys = []string{}
+
+// SeedManifestFormat identifies a seed manifest file
+const SeedManifestFormat = "onivex-seeds"
+
+// SeedManifest is a signed list of seeds, served by seeds at
+// /api/seeds/manifest. Serial only ever grows, so an old manifest can't be
+// replayed to bring back retired seeds.
+type SeedManifest struct {
+	Format     string              `json:"format"`
+	Serial     uint64              `json:"serial"`
+	Issued     time.Time           `json:"issued"`
+	Expires    time.Time           `json:"expires"`
+	Seeds      []string            `json:"seeds"`
+	Signatures []ManifestSignature `json:"signatures"`
+}
+
+// ManifestSignature is one maintainer's signature over a manifest
+type ManifestSignature struct {
+	Key string `json:"key"`
+	Sig string `json:"sig"`
+}
+
+func (m *SeedManifest) payload() []byte {
+	lines := []string{"onivex-seeds-v1", strconv.FormatUint(m.Serial, 10),
+		m.Issued.UTC().Format(time.RFC3339), m.Expires.UTC().Format(time.RFC3339)}
+	for _, s := range m.Seeds {
+		lines = append(lines, strings.ToLower(s))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Sign adds key's signature, replacing an earlier one by the same key
+func (m *SeedManifest) Sign(key ed25519.PrivateKey) {
+	pub := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	sig := ManifestSignature{Key: pub, Sig: base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.payload()))}
+	for i, s := range m.Signatures {
+		if s.Key == pub {
+			m.Signatures[i] = sig
+			return
+		}
+	}
+	m.Signatures = append(m.Signatures, sig)
+}
+
+// Signers returns the keys with a valid signature on the manifest
+func (m *SeedManifest) Signers() []string {
+	var keys []string
+	for _, s := range m.Signatures {
+		pub, err := base64.StdEncoding.DecodeString(s.Key)
+		sig, err2 := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil || err2 != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		if ed25519.Verify(ed25519.PublicKey(pub), m.payload(), sig) {
+			keys = append(keys, s.Key)
+		}
+	}
+	return keys
+}
+
+// Check validates the manifest's contents, ignoring signatures
+func (m *SeedManifest) Check() error {
+	switch {
+	case m.Format != SeedManifestFormat:
+		return fmt.Errorf("not a seed manifest")
+	case len(m.Seeds) == 0:
+		return fmt.Errorf("manifest lists no seeds")
+	case !m.Expires.After(m.Issued):
+		return fmt.Errorf("manifest expires before it was issued")
+	}
+	for _, s := range m.Seeds {
+		if !network.ValidOnion(s) {
+			return fmt.Errorf("manifest seed %q is not a v3 onion", s)
+		}
+	}
+	return nil
+}
+
+// Verify checks the manifest is well-formed, current and signed by at
+// least one trusted key
+func (m *SeedManifest) Verify(trusted []string) error {
+	if err := m.Check(); err != nil {
+		return err
+	}
+	if time.Now().After(m.Expires) {
+		return fmt.Errorf("manifest %d expired on %s", m.Serial, m.Expires.Format("2006-01-02"))
+	}
+	for _, signer := range m.Signers() {
+		for _, key := range trusted {
+			if signer == key {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("manifest %d is not signed by a trusted key", m.Serial)
+}
+
+// TrustedManifestKeys are MaintainerKeys plus the seed_manifest_keys setting
+func TrustedManifestKeys() []string {
+	return append(append([]string{}, MaintainerKeys...), config.Current().Network.SeedManifestKeys...)
+}
+
+// ParseSeedManifest decodes a manifest without verifying it
+func ParseSeedManifest(data []byte) (*SeedManifest, error) {
+	var m SeedManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("not a seed manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// SeedList decides which seeds Bootstrap syncs with. In order of
+// preference: seeds fixed in code (tests), the seeds setting (file, env or
+// -seed), the latest verified manifest, and finally BootstrapPeers.
+type SeedList struct {
+	mu       sync.RWMutex
+	fixed    []string
+	path     string
+	manifest *SeedManifest
+}
+
+// FixedSeeds is a SeedList that ignores settings and manifests
+func FixedSeeds(addrs ...string) *SeedList {
+	return &SeedList{fixed: append([]string{}, addrs...)}
+}
+
+// LoadSeeds reads the cached manifest from data/seed_manifest.json. A cached
+// manifest that no longer verifies is kept for its serial but not used.
+func LoadSeeds(dataDir string) *SeedList {
+	sl := &SeedList{path: filepath.Join(dataDir, "seed_manifest.json")}
+	data, err := os.ReadFile(sl.path)
+	if err != nil {
+		return sl
+	}
+	m, err := ParseSeedManifest(data)
+	if err != nil {
+		slog.Warn("⚠️  Ignoring saved seed manifest", "file", sl.path, "err", err)
+		return sl
+	}
+	sl.manifest = m
+	return sl
+}
+
+// List returns the seeds to use right now
+func (sl *SeedList) List() []string {
+	seeds, _ := sl.Source()
+	return seeds
+}
+
+// Source is List plus where the seeds came from, for the UI and logs
+func (sl *SeedList) Source() ([]string, string) {
+	if sl == nil {
+		return nil, "none"
+	}
+	if sl.fixed != nil {
+		return sl.fixed, "fixed"
+	}
+	if s := config.Current().Seeds; len(s) > 0 {
+		return s, "settings"
+	}
+	sl.mu.RLock()
+	m := sl.manifest
+	sl.mu.RUnlock()
+	if m != nil && m.Verify(TrustedManifestKeys()) == nil {
+		return m.Seeds, fmt.Sprintf("manifest #%d", m.Serial)
+	}
+	return BootstrapPeers, "built-in"
+}
+
+// Has reports whether addr is one of the current seeds
+func (sl *SeedList) Has(addr string) bool {
+	for _, s := range sl.List() {
+		if strings.EqualFold(s, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Manifest returns the cached manifest, verified or not
+func (sl *SeedList) Manifest() *SeedManifest {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.manifest
+}
+
+// Offer installs m if it verifies and is newer than what we have. It
+// reports whether m was installed.
+func (sl *SeedList) Offer(m *SeedManifest) (bool, error) {
+	if err := m.Verify(TrustedManifestKeys()); err != nil {
+		return false, err
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.manifest != nil && m.Serial <= sl.manifest.Serial {
+		return false, nil
+	}
+	sl.manifest = m
+	if sl.path != "" {
+		data, _ := json.MarshalIndent(m, "", "  ")
+		if err := os.WriteFile(sl.path, data, 0600); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// RefreshSeeds asks every current seed for its manifest and installs the
+// newest one that verifies
+func (pm *PeerManager) RefreshSeeds() {
+	if pm.Seeds == nil || pm.Seeds.fixed != nil || len(TrustedManifestKeys()) == 0 {
+		return
+	}
+	client := pm.GetTorClient()
+	for _, seed := range pm.Seeds.List() {
+		resp, err := client.Get("http://" + seed + "/api/seeds/manifest")
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		m, err := ParseSeedManifest(data)
+		if err != nil {
+			continue
+		}
+		installed, err := pm.Seeds.Offer(m)
+		switch {
+		case err != nil && installed:
+			slog.Warn("⚠️  Seed manifest installed but not saved", "serial", m.Serial, "err", err)
+		case err != nil:
+			slog.Warn("⚠️  Rejected seed manifest", "seed", seed, "err", err)
+		case installed:
+			slog.Info("🌱 Installed seed manifest", "serial", m.Serial, "seeds", len(m.Seeds))
+		}
+	}
+}
+
+// StartSeedRefresh refreshes the seed manifest on the seed_manifest_refresh
+// interval, read live so it can be changed (or turned off) from settings
+func (pm *PeerManager) StartSeedRefresh() {
+	go func() {
+		for {
+			interval := config.Current().Network.SeedManifestRefresh.D()
+			if interval == 0 {
+				time.Sleep(time.Hour)
+				continue
+			}
+			pm.RefreshSeeds()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// ManifestHandler serves the manifest file at path, re-read on every
+// request so a seed operator can drop in a new one without a restart.
+func ManifestHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			http.Error(w, "No seed manifest", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"onivex/config"
+	"onivex/network"
+)
+
+// Signed requests carry the sender's onion and an ed25519 signature by the
+// key behind it, so the receiver learns who is calling even though Tor hides
+// the connection's origin.
+const (
+	OriginHeader    = "X-Onivex-Origin"
+	TimestampHeader = "X-Onivex-Timestamp"
+	SignatureHeader = "X-Onivex-Signature"
+
+	// signatureSkew bounds clock drift and how long a signature can be replayed
+	signatureSkew = 5 * time.Minute
+)
+
+// signingPayload binds the signature to the method, target host and path
+// so it can't be replayed against another node or endpoint.
+func signingPayload(method, host, uri, origin, ts string) []byte {
+	return []byte(strings.Join([]string{"onivex-request-v1", method, strings.ToLower(host), uri, origin, ts}, "\n"))
+}
+
+// SignRequest stamps req with our origin headers if the transport can sign
+func SignRequest(req *http.Request, t network.Transport) {
+	signer, ok := t.(network.Signer)
+	if !ok {
+		return
+	}
+	origin := t.Address()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := signer.Sign(signingPayload(req.Method, req.URL.Host, req.URL.RequestURI(), origin, ts))
+	if sig == nil {
+		return
+	}
+	req.Header.Set(OriginHeader, origin)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+}
+
+// VerifyRequest returns the onion that signed r. self is our own address;
+// requests signed for a different host are rejected.
+func VerifyRequest(r *http.Request, self string) (string, error) {
+	origin := r.Header.Get(OriginHeader)
+	if origin == "" {
+		return "", fmt.Errorf("request is not signed")
+	}
+	if !strings.EqualFold(hostOnly(r.Host), self) {
+		return "", fmt.Errorf("request was signed for %s", r.Host)
+	}
+
+	ts := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("bad timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > signatureSkew || skew < -signatureSkew {
+		return "", fmt.Errorf("signature expired")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return "", fmt.Errorf("bad signature encoding")
+	}
+	if !network.VerifyOnionSignature(origin, signingPayload(r.Method, r.Host, r.URL.RequestURI(), origin, ts), sig) {
+		return "", fmt.Errorf("bad signature for %s", origin)
+	}
+	return origin, nil
+}
+
+func hostOnly(hostport string) string {
+	if i := strings.LastIndex(hostport, ":"); i != -1 {
+		return hostport[:i]
+	}
+	return hostport
+}
+
+// signingTransport, in friends-only mode, signs every outgoing request and
+// refuses to contact anyone who isn't a friend. Otherwise requests go out
+// unsigned, so the peers we search and download from don't learn our
+// onion; code that must authenticate (federation, gossip, the DHT) calls
+// SignRequest itself.
+type signingTransport struct {
+	base http.RoundTripper
+	pm   *PeerManager
+}
+
+func (st *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !st.pm.allowed(hostOnly(req.URL.Host)) {
+		return nil, fmt.Errorf("friends-only mode: %s is not a friend", req.URL.Host)
+	}
+	req = req.Clone(req.Context())
+	if st.pm.FriendsOnly && req.Header.Get(OriginHeader) == "" {
+		SignRequest(req, st.pm.Transport)
+	}
+
+	protected := powProtected(req.Method, req.URL.Path)
+	if d := st.pm.pow.get(req.URL.Host); protected && d > 0 && d <= config.Current().PoW.MaxDifficulty {
+		if err := st.pm.pow.stamp(req, d); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := st.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	st.pm.pow.note(req.URL.Host, resp.Header.Get(PoWDifficultyHeader))
+
+	// The peer wants a (harder) stamp: pay once, unless it asks for more
+	// than we would demand ourselves
+	d, _ := strconv.Atoi(resp.Header.Get(PoWDifficultyHeader))
+	if resp.StatusCode != http.StatusPreconditionRequired || !protected || d <= 0 || d > config.Current().PoW.MaxDifficulty {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	if err := st.pm.pow.stamp(retry, d); err != nil {
+		return nil, err
+	}
+	return st.base.RoundTrip(retry)
+}
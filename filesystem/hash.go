@@ -0,0 +1,64 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// hashEntry caches a content hash until the file's size or mtime changes
+type hashEntry struct {
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+var (
+	hashMu    sync.Mutex
+	hashCache = make(map[string]hashEntry)
+)
+
+// HashFile returns the hex SHA-256 of a file, reusing the cached value
+// when the file hasn't changed since it was last hashed.
+func HashFile(path string, info os.FileInfo) (string, error) {
+	hashMu.Lock()
+	if e, ok := hashCache[path]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+		hashMu.Unlock()
+		return e.Hash, nil
+	}
+	hashMu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	hashMu.Lock()
+	hashCache[path] = hashEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: sum}
+	hashMu.Unlock()
+	return sum, nil
+}
+
+// FindByHash returns the shared file with the given content hash, if any
+func FindByHash(hash string) (FileMeta, bool) { return Default.FindByHash(hash) }
+
+// FindByHash returns the shared file with the given content hash, if any
+func (s *Share) FindByHash(hash string) (FileMeta, bool) {
+	files, _ := s.GetFileList()
+	for _, f := range files {
+		if f.Hash == hash {
+			return f, true
+		}
+	}
+	return FileMeta{}, false
+}
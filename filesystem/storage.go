@@ -1,9 +1,11 @@
 package filesystem
 
 import (
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -13,35 +15,88 @@ type FileMeta struct {
 	Size int64  `json:"size"`
 	Path string `json:"path"`
 
-	// Future-proofing: These fields don't exist yet, but if V2 adds them,
-	// V1 clients will simply ignore them thanks to 'omitempty'
+	// Hash is the hex SHA-256 of the file contents (used as the DHT key).
+	// V1 clients will simply ignore it thanks to 'omitempty'
 	Hash      string `json:"hash,omitempty"`
 	Thumbnail string `json:"thumbnail,omitempty"`
 }
 
+// Share is a pair of shared (uploads) and received (downloads) folders.
+// The daemon uses Default; the simulation harness gives each node its own.
+type Share struct {
+	UploadsDir   string
+	DownloadsDir string
+}
+
+// Default is the share rooted in the working directory
+var Default = &Share{UploadsDir: "uploads", DownloadsDir: "downloads"}
+
+// NewShare returns a share with uploads/ and downloads/ under root
+func NewShare(root string) *Share {
+	return &Share{
+		UploadsDir:   filepath.Join(root, "uploads"),
+		DownloadsDir: filepath.Join(root, "downloads"),
+	}
+}
+
 // EnsureDirectories creates uploads/downloads folders if they don't exist
-func EnsureDirectories() {
-	dirs := []string{"uploads", "downloads"}
+func EnsureDirectories() { Default.EnsureDirectories() }
+
+// GetFileHandler returns an HTTP handler that serves the uploads folder
+func GetFileHandler() http.Handler { return Default.GetFileHandler() }
+
+// GetFileList scans the uploads folder and returns JSON-ready metadata
+func GetFileList() ([]FileMeta, error) { return Default.GetFileList() }
+
+// GetDownloadsList scans the downloads folder for the local library
+func GetDownloadsList() ([]FileMeta, error) { return Default.GetDownloadsList() }
+
+// SearchLocal matches query against the names of shared files
+func SearchLocal(query string) []FileMeta { return Default.SearchLocal(query) }
+
+// EnsureDirectories creates this share's folders if they don't exist
+func (s *Share) EnsureDirectories() {
+	dirs := []string{s.UploadsDir, s.DownloadsDir}
 	for _, d := range dirs {
 		if _, err := os.Stat(d); os.IsNotExist(err) {
-			os.Mkdir(d, 0755)
+			os.MkdirAll(d, 0755)
 		}
 	}
 }
 
 // GetFileHandler returns an HTTP handler that serves the uploads folder
-func GetFileHandler() http.Handler {
-	return http.FileServer(http.Dir("./uploads"))
+func (s *Share) GetFileHandler() http.Handler {
+	return http.FileServer(http.Dir(s.UploadsDir))
 }
 
 // GetFileList scans the uploads folder and returns JSON-ready metadata
-func GetFileList() ([]FileMeta, error) {
-	return scanDirectory("uploads")
+func (s *Share) GetFileList() ([]FileMeta, error) {
+	return scanDirectory(s.UploadsDir)
+}
+
+// FileNames lists the names of shared files, sorted, without reading or
+// hashing them
+func (s *Share) FileNames() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.UploadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.UploadsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			names = append(names, d.Name())
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
 }
 
 // GetDownloadsList scans the downloads folder for the local library
-func GetDownloadsList() ([]FileMeta, error) {
-	return scanDirectory("downloads")
+func (s *Share) GetDownloadsList() ([]FileMeta, error) {
+	return scanDirectory(s.DownloadsDir)
 }
 
 // Helper function to scan a specific directory
@@ -60,10 +115,12 @@ func scanDirectory(dirName string) ([]FileMeta, error) {
 			relPath := strings.TrimPrefix(path, dirName)
 			relPath = filepath.ToSlash(relPath)
 
+			hash, _ := HashFile(path, info)
 			files = append(files, FileMeta{
 				Name: info.Name(),
 				Size: info.Size(),
 				Path: relPath,
+				Hash: hash,
 			})
 		}
 		return nil
@@ -72,8 +129,9 @@ func scanDirectory(dirName string) ([]FileMeta, error) {
 	return files, err
 }
 
-func SearchLocal(query string) []FileMeta {
-	allFiles, _ := GetFileList()
+// SearchLocal matches query against the names of shared files
+func (s *Share) SearchLocal(query string) []FileMeta {
+	allFiles, _ := s.GetFileList()
 	if query == "" {
 		return allFiles
 	}
@@ -87,4 +145,4 @@ func SearchLocal(query string) []FileMeta {
 		}
 	}
 	return results
-}
\ No newline at end of file
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"onivex/network"
+)
+
+const keyUsage = `usage: onivex key <command> [flags]
+
+  migrate [name...]         encrypt plaintext keys in ./data (default: all)
+  export  [-name n] [-out f] [-mnemonic]
+                            write an encrypted backup bundle, or the key as words
+  import  [-name n] [-force] [-mnemonic] [file]
+                            restore a bundle or mnemonic (file or stdin)
+  address [-name n] [file]  print the onion address of a key or bundle
+
+Local keys are unlocked with -key-passphrase-file or ONIVEX_KEY_PASSPHRASE.
+Bundles use -export-passphrase-file or ONIVEX_EXPORT_PASSPHRASE.`
+
+// runKeyCommand handles `onivex key <subcommand>`
+func runKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, keyUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "migrate":
+		return keyMigrate(args[1:])
+	case "export":
+		err = keyExport(args[1:])
+	case "import":
+		err = keyImport(args[1:])
+	case "address":
+		err = keyAddress(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown key command %q\n\n%s\n", args[0], keyUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// exportFlags registers the bundle passphrase source on fs
+func exportFlags(fs *flag.FlagSet) *network.KeyOptions {
+	opts := &network.KeyOptions{Env: "ONIVEX_EXPORT_PASSPHRASE"}
+	fs.StringVar(&opts.PassphraseFile, "export-passphrase-file", "", "File containing the bundle passphrase (or set ONIVEX_EXPORT_PASSPHRASE)")
+	return opts
+}
+
+// keyMigrate encrypts plaintext keys in ./data. With no names, every key
+// file found there is migrated.
+func keyMigrate(args []string) int {
+	fs := flag.NewFlagSet("key migrate", flag.ExitOnError)
+	network.RegisterKeyFlags(fs)
+	fs.Parse(args)
+
+	names := fs.Args()
+	if len(names) == 0 {
+		names = network.ListKeys()
+	}
+	if len(names) == 0 {
+		fmt.Println("No keys found in ./data")
+		return 0
+	}
+
+	pass, err := network.Keys.Passphrase("identity keys", true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	failed := false
+	for _, name := range names {
+		migrated, err := network.MigrateKey(name, pass)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", name, err)
+			failed = true
+		case migrated:
+			fmt.Printf("🔒 Encrypted %s\n", name)
+		default:
+			fmt.Printf("✔️  %s is already encrypted\n", name)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func keyExport(args []string) error {
+	fs := flag.NewFlagSet("key export", flag.ExitOnError)
+	name := fs.String("name", network.ClientKeyName, "Key to export (data/<name>.key)")
+	out := fs.String("out", "-", "Output file, or - for stdout")
+	mnemonic := fs.Bool("mnemonic", false, "Print the key as backup words instead of an encrypted bundle")
+	network.RegisterKeyFlags(fs)
+	bundleOpts := exportFlags(fs)
+	fs.Parse(args)
+
+	priv, err := network.LoadKey(*name)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if *mnemonic {
+		fmt.Fprintln(os.Stderr, "⚠️  Anyone with these words can run your onion address. Store them offline.")
+		data = []byte(network.KeyMnemonic(priv) + "\n")
+	} else {
+		pass, err := bundleOpts.Passphrase("the backup bundle", true)
+		if err != nil {
+			return err
+		}
+		if data, err = network.ExportBundle(priv, pass); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "📦 Exported %s (%s) to %s\n", *name, network.OnionAddress(priv), *out)
+	return nil
+}
+
+func keyImport(args []string) error {
+	fs := flag.NewFlagSet("key import", flag.ExitOnError)
+	name := fs.String("name", network.ClientKeyName, "Key to write (data/<name>.key)")
+	force := fs.Bool("force", false, "Replace an existing key with a different address")
+	mnemonic := fs.Bool("mnemonic", false, "Input is backup words rather than a bundle")
+	network.RegisterKeyFlags(fs)
+	bundleOpts := exportFlags(fs)
+	fs.Parse(args)
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var priv ed25519.PrivateKey
+	if *mnemonic {
+		priv, err = network.KeyFromMnemonic(string(data))
+	} else {
+		var b *network.IdentityBundle
+		if b, err = network.ReadBundle(data); err != nil {
+			return err
+		}
+		var pass string
+		if pass, err = bundleOpts.Passphrase("the backup bundle", false); err != nil {
+			return err
+		}
+		priv, err = b.Open(pass)
+	}
+	if err != nil {
+		return err
+	}
+	addr := network.OnionAddress(priv)
+
+	existing, err := network.LoadKey(*name)
+	switch {
+	case err == nil && existing.Equal(priv):
+		fmt.Printf("✔️  %s already holds %s\n", *name, addr)
+		return nil
+	case err == nil && !*force:
+		return fmt.Errorf("%s already holds %s; use -force to replace it", *name, network.OnionAddress(existing))
+	case err != nil && !errors.Is(err, os.ErrNotExist) && !*force:
+		return fmt.Errorf("could not check existing key (use -force to replace it): %w", err)
+	}
+
+	var pass string
+	if network.Keys.WantsEncryption() {
+		if pass, err = network.Keys.Passphrase(*name, true); err != nil {
+			return err
+		}
+	}
+	if err := network.SaveKey(*name, priv, pass); err != nil {
+		return err
+	}
+	fmt.Printf("📥 Imported %s as %s\n", addr, *name)
+	return nil
+}
+
+func keyAddress(args []string) error {
+	fs := flag.NewFlagSet("key address", flag.ExitOnError)
+	name := fs.String("name", network.ClientKeyName, "Key to inspect (data/<name>.key)")
+	network.RegisterKeyFlags(fs)
+	bundleOpts := exportFlags(fs)
+	fs.Parse(args)
+
+	// The address a bundle records in the clear isn't covered by its
+	// encryption, so derive it from the key instead
+	if fs.NArg() > 0 {
+		data, err := readInput(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		b, err := network.ReadBundle(data)
+		if err != nil {
+			return err
+		}
+		pass, err := bundleOpts.Passphrase("the backup bundle", false)
+		if err != nil {
+			return err
+		}
+		priv, err := b.Open(pass)
+		if err != nil {
+			return err
+		}
+		fmt.Println(network.OnionAddress(priv))
+		return nil
+	}
+
+	priv, err := network.LoadKey(*name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(network.OnionAddress(priv))
+	return nil
+}
+
+// readInput reads a file, or stdin for "" and "-"
+func readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
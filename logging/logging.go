@@ -0,0 +1,240 @@
+// Package logging sets up the slog logger every package writes to and
+// keeps sensitive values out of it.
+//
+// Log onion addresses, search queries and file paths as attributes under
+// the keys below, never inside the message, so redaction can find them:
+//
+//	slog.Info("🔭 New peer discovered", "peer", addr)
+//	slog.Debug("🔍 Searching", "query", q, "peers", n)
+//
+// Onion addresses are also caught anywhere else in a string, such as in
+// error text.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"onivex/config"
+)
+
+var (
+	onionKeys = keySet("peer", "addr", "onion", "origin", "seed", "sibling")
+	queryKeys = keySet("query")
+	pathKeys  = keySet("path", "file")
+)
+
+func keySet(keys ...string) map[string]bool {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+var (
+	onionPattern = regexp.MustCompile(`\b[a-z2-7]{56}\.onion\b|\b[a-z2-7]{56}\b`)
+	queryParam   = regexp.MustCompile(`([?&]q=)[^&\s"]*`)
+)
+
+// salt keeps hashed values from being matched against a dictionary of
+// known queries or onions. It changes every run, so the same value only
+// hashes the same within one log session.
+var salt = func() []byte {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b
+}()
+
+func short(s string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(s))
+	return "#" + hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// Onion masks an onion address: its first 8 characters, or a hash
+func Onion(mode, addr string) string {
+	switch mode {
+	case "off":
+		return addr
+	case "truncate":
+		if len(addr) > 8 {
+			return addr[:8] + "…"
+		}
+		return addr
+	}
+	return short(strings.ToLower(addr))
+}
+
+// Query masks a search query: its first 2 letters, or a hash
+func Query(mode, q string) string {
+	switch mode {
+	case "off":
+		return q
+	case "truncate":
+		if r := []rune(q); len(r) > 2 {
+			return string(r[:2]) + "…"
+		}
+		return q
+	}
+	return short(strings.ToLower(q))
+}
+
+// Path masks a file path down to its extension, hashed or not
+func Path(mode, p string) string {
+	switch mode {
+	case "off":
+		return p
+	case "truncate":
+		return "…" + filepath.Ext(p)
+	}
+	return short(p) + filepath.Ext(p)
+}
+
+// scrub masks onions and ?q= parameters inside free text
+func scrub(mode, s string) string {
+	if mode == "off" {
+		return s
+	}
+	s = onionPattern.ReplaceAllStringFunc(s, func(m string) string { return Onion(mode, m) })
+	return queryParam.ReplaceAllStringFunc(s, func(m string) string {
+		prefix, q, _ := strings.Cut(m, "=")
+		return prefix + "=" + Query(mode, q)
+	})
+}
+
+func redactAttr(mode string, a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		out := make([]any, len(attrs))
+		for i, ga := range attrs {
+			out[i] = redactAttr(mode, ga)
+		}
+		return slog.Group(a.Key, out...)
+	case slog.KindString:
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, scrub(mode, x.Error()))
+		case fmt.Stringer:
+			v = slog.StringValue(x.String())
+		case []string:
+			out := make([]string, len(x))
+			for i, s := range x {
+				out[i] = redactString(mode, a.Key, s)
+			}
+			return slog.Any(a.Key, out)
+		default:
+			return slog.Attr{Key: a.Key, Value: v}
+		}
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+	return slog.String(a.Key, redactString(mode, a.Key, v.String()))
+}
+
+func redactString(mode, key, s string) string {
+	switch {
+	case onionKeys[key]:
+		return Onion(mode, s)
+	case queryKeys[key]:
+		return Query(mode, s)
+	case pathKeys[key]:
+		return Path(mode, s)
+	}
+	return scrub(mode, s)
+}
+
+// handler applies the live level and redaction settings in front of the
+// text or JSON handler
+type handler struct {
+	next slog.Handler
+}
+
+func level() slog.Level {
+	var l slog.Level
+	l.UnmarshalText([]byte(config.Current().Logging.Level))
+	return l
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool { return l >= level() }
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	mode := config.Current().Logging.Redact
+	if mode == "off" {
+		return h.next.Handle(ctx, r)
+	}
+	out := slog.NewRecord(r.Time, r.Level, scrub(mode, r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(mode, a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	mode := config.Current().Logging.Redact
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(mode, a)
+	}
+	return &handler{next: h.next.WithAttrs(out)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name)}
+}
+
+// Setup points slog (and the standard log package) at the configured
+// sinks. Call it once settings are loaded; the level and redaction mode
+// are re-read on every record, so changing them needs no restart.
+func Setup() error {
+	s := config.Current().Logging
+	var writers []io.Writer
+	for _, sink := range s.Sinks {
+		switch sink {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		default:
+			if err := os.MkdirAll(filepath.Dir(sink), 0700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(sink, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return fmt.Errorf("log sink %s: %w", sink, err)
+			}
+			writers = append(writers, f)
+		}
+	}
+
+	// The wrapper decides what is enabled, so let everything through here
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var next slog.Handler
+	if s.Format == "json" {
+		next = slog.NewJSONHandler(io.MultiWriter(writers...), opts)
+	} else {
+		next = slog.NewTextHandler(io.MultiWriter(writers...), opts)
+	}
+	slog.SetDefault(slog.New(&handler{next: next}))
+	return nil
+}
+
+// Fatal logs at error level and exits, like log.Fatal
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+const testOnion = "abcdefghijklmnopqrstuvwxyz234567abcdefghijklmnopqrstuvwx.onion"
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestScrub(t *testing.T) {
+	text := "dial " + testOnion + " failed: GET http://x/api/search?q=secret+stuff&n=1"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"off", text},
+		{"truncate", "dial abcdefgh… failed: GET http://x/api/search?q=se…&n=1"},
+		{"hash", "dial " + short(testOnion) + " failed: GET http://x/api/search?q=" + short("secret+stuff") + "&n=1"},
+	}
+	for _, tt := range tests {
+		if got := scrub(tt.mode, text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestScrubLeavesOtherTextAlone(t *testing.T) {
+	for _, s := range []string{"", "no onions here", "too short abcdefgh.onion", "?query=x"} {
+		if got := scrub("hash", s); got != s {
+			t.Errorf("%q scrubbed to %q", s, got)
+		}
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{"onion key", slog.String("peer", testOnion), "abcdefgh…"},
+		{"query key", slog.String("query", "ubuntu iso"), "ub…"},
+		{"path key", slog.String("path", "/home/me/secret.mkv"), "….mkv"},
+		{"other key", slog.String("err", "from "+testOnion), "from abcdefgh…"},
+		{"plain value", slog.String("status", "ok"), "ok"},
+		{"error", slog.Any("err", errors.New("no route to "+testOnion)), "no route to abcdefgh…"},
+		{"stringer", slog.Any("peer", stringer(testOnion)), "abcdefgh…"},
+		{"number", slog.Int("peer", 42), "42"},
+		{"list", slog.Any("peer", []string{testOnion, "short"}), "[abcdefgh… short]"},
+		{"group", slog.Group("req", slog.String("query", "ubuntu iso"), slog.Int("n", 3)), "[query=ub… n=3]"},
+	}
+	for _, tt := range tests {
+		got := redactAttr("truncate", tt.attr)
+		if got.Key != tt.attr.Key {
+			t.Errorf("%s: key %q became %q", tt.name, tt.attr.Key, got.Key)
+		}
+		if s := got.Value.String(); s != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, s, tt.want)
+		}
+	}
+}
+
+func TestRedactAttrOff(t *testing.T) {
+	for _, a := range []slog.Attr{
+		slog.String("peer", testOnion),
+		slog.String("query", "ubuntu iso"),
+		slog.Any("err", errors.New("no route to "+testOnion)),
+	} {
+		if got := redactAttr("off", a); got.Value.String() != a.Value.String() {
+			t.Errorf("%s: got %q, want it unchanged", a.Key, got.Value.String())
+		}
+	}
+}
+
+func TestRedactAttrHashIsStableWithinARun(t *testing.T) {
+	a := redactAttr("hash", slog.String("peer", testOnion)).Value.String()
+	b := redactAttr("hash", slog.String("onion", "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567ABCDEFGHIJKLMNOPQRSTUVWX.onion")).Value.String()
+	if a != b || a == testOnion {
+		t.Errorf("hashes %q and %q should match and hide the onion", a, b)
+	}
+}
@@ -1,143 +1,134 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
-	"strings"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"time"
 
-	"onivex/bloom"
 	"onivex/config" // <--- IMPORTED
-	"onivex/discovery"
 	"onivex/filesystem"
+	"onivex/logging"
 	"onivex/network"
+	"onivex/node"
 	"onivex/webui"
 )
 
-// Wrapper to log file access requests
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(r.URL.Path) > 1 && r.URL.Path[0:4] != "/api" {
-			fmt.Printf("📂 Serving File: %s to %s\n", r.URL.Path, r.RemoteAddr)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "key":
+			os.Exit(runKeyCommand(os.Args[2:]))
+		case "auth":
+			os.Exit(runAuthCommand(os.Args[2:]))
+		case "seeds":
+			os.Exit(runSeedsCommand(os.Args[2:]))
+		case "help":
+			fmt.Fprintln(os.Stderr, clientUsage)
+			fmt.Fprintln(os.Stderr, "\nWithout a command, onivex runs the node; see -help for its flags. Other commands: key, auth, seeds.")
+			os.Exit(0)
 		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-// Validates incoming versions and stamps outgoing responses
-func versionMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 1. Tag response with our version
-		w.Header().Set("X-Onivex-Version", config.ProtocolVersion) // <--- UPDATED
-
-		// 2. Check client version (Logging only for now)
-		clientVer := r.Header.Get("X-Onivex-Version")
-		if clientVer != "" && clientVer != config.ProtocolVersion { // <--- UPDATED
-			// fmt.Printf("⚠️  Peer Version Mismatch: %s (Them) vs %s (Us)\n", clientVer, config.ProtocolVersion)
+		if clientCommands[os.Args[1]] {
+			os.Exit(runClientCommand(os.Args[1], os.Args[2:]))
 		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
-}
-
-func main() {
+	cwd, _ := os.Getwd()
+	configPath := flag.String("config", filepath.Join(cwd, "data", "config.json"), "Settings file (edited from the Settings page)")
 	port := flag.Int("port", 8080, "Web UI Port")
+	transportKind := flag.String("transport", "tor", "Mesh transport: tor, or loopback for local testing without network access")
+	identityFlag := flag.String("identity", "persistent", "Onion identity: persistent, ephemeral (new address each run) or rotating")
+	rotateEvery := flag.Duration("rotate-every", 6*time.Hour, "How often a rotating identity gets a new address")
+	rotateGrace := flag.Duration("rotate-grace", 10*time.Minute, "How long a rotated-out address keeps serving in-flight transfers")
+	friendsOnly := flag.Bool("friends-only", false, "Only talk to friends (managed in the web UI) and don't gossip the peer table")
+	var seeds []string
+	flag.Func("seed", "Seed onion to bootstrap from; repeatable, replaces the configured and manifest seeds", func(v string) error {
+		seeds = append(seeds, v)
+		return nil
+	})
+	torOpts := network.RegisterTorFlags(flag.CommandLine)
+	network.RegisterKeyFlags(flag.CommandLine)
 	flag.Parse()
 
-	filesystem.EnsureDirectories()
-
-	t, onion, err := network.SetupTor("client_identity")
+	store, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Fatal Network Error: %v", err)
+		log.Fatalf("Bad settings: %v", err)
 	}
-	defer t.Close()
-	defer onion.Close()
-
-	myAddress := fmt.Sprintf("%v.onion", onion.ID)
-
-	peers := discovery.NewPeerManager(t)
-	peers.AddPeer(myAddress)
-	peers.StartPersistence(5 * time.Minute)
-
-	go webui.Start(*port, myAddress, peers, t)
-
-	fmt.Printf("\n✨ ONIVEX CLIENT LIVE (v%s)\n", config.ProtocolVersion) // <--- UPDATED
-	fmt.Printf("👉 Tor Access: http://%s\n", myAddress)
-	fmt.Printf("👉 Control UI: http://127.0.0.1:%d\n\n", *port)
-
-	mux := http.NewServeMux()
-
-	fileHandler := filesystem.GetFileHandler()
-	mux.Handle("/", loggingMiddleware(fileHandler))
-
-	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("OniVex Online"))
-	})
-
-	mux.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			var payload map[string]string
-			if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
-				if addr := payload["addr"]; addr != "" && addr != myAddress {
-					peers.AddPeer(addr)
-				}
-			}
+	// Flags given on the command line win over the file and the environment
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			err = store.Override(func(s *config.Settings) { s.UIPort = *port })
+		case "transport":
+			err = store.Override(func(s *config.Settings) { s.Transport = *transportKind })
+		case "identity":
+			err = store.Override(func(s *config.Settings) { s.Identity = *identityFlag })
+		case "friends-only":
+			err = store.Override(func(s *config.Settings) { s.FriendsOnly = *friendsOnly })
+		case "seed":
+			err = store.Override(func(s *config.Settings) { s.Seeds = seeds })
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(peers.GetRandomPeers(50))
-	})
-
-	mux.HandleFunc("/api/filter", func(w http.ResponseWriter, r *http.Request) {
-		files, _ := filesystem.GetFileList()
-		filter := bloom.New(1000, 0.01)
-		for _, f := range files {
-			name := strings.ToLower(f.Name)
-			filter.Add([]byte(name))
-			tokens := strings.FieldsFunc(name, func(r rune) bool {
-				return r == '.' || r == ' ' || r == '_' || r == '-'
-			})
-			for _, token := range tokens {
-				if len(token) > 0 {
-					filter.Add([]byte(token))
-				}
-			}
+		if err != nil {
+			log.Fatalf("-%s: %v", f.Name, err)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(filter)
 	})
+	settings := store.Get()
+	if err := logging.Setup(); err != nil {
+		log.Fatalf("Logging: %v", err)
+	}
 
-	mux.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		results := filesystem.SearchLocal(query)
-		if len(results) > 0 {
-			fmt.Printf("💡 Found match for forwarded query '%s'\n", query)
-		}
-	})
+	identity, err := network.ParseIdentityMode(settings.Identity)
+	if err != nil {
+		logging.Fatal("❌ Bad identity mode", "err", err)
+	}
 
-	mux.HandleFunc("/api/index", func(w http.ResponseWriter, r *http.Request) {
-		files, _ := filesystem.GetFileList()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(files)
-	})
+	filesystem.EnsureDirectories()
 
-	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		results := filesystem.SearchLocal(query)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(results)
-	})
+	// Show Tor bootstrap progress on the UI port until the node is up
+	stopBootScreen := webui.StartBootScreen(settings.UIPort)
+	transport, err := network.OpenTransport(settings.Transport, identity.KeyName(network.ClientKeyName), *torOpts)
+	stopBootScreen()
+	if err != nil {
+		logging.Fatal("❌ Fatal network error", "err", err)
+	}
+	defer transport.Close()
+
+	n := node.New(transport, filepath.Join(cwd, "data"), filesystem.Default)
+	myAddress := n.Addr
+	peers := n.Peers
+	peers.FriendsOnly = settings.FriendsOnly
+	if settings.FriendsOnly {
+		slog.Info("🤝 Friends-only mode", "friends", len(peers.Friends.List()))
+		if identity != network.IdentityPersistent {
+			slog.Warn("⚠️  Friends know you by your onion; use -identity persistent in friends-only mode")
+		}
+	}
+	peers.StartPersistence(settings.Network.PersistInterval.D())
 
-	go func() {
-		fmt.Println("⏳ Waiting for Tor circuit stability (15s)...")
-		time.Sleep(15 * time.Second)
-		for {
-			peers.Bootstrap(myAddress)
-			time.Sleep(15 * time.Minute)
+	auth, err := webui.LoadAuth(filepath.Join(cwd, "data"), settings.UIPort)
+	if err != nil {
+		logging.Fatal("❌ Could not set up the UI access token", "err", err)
+	}
+	go webui.Start(settings.UIPort, auth, peers, transport, n.Uploads)
+
+	slog.Info("✨ ONIVEX CLIENT LIVE", "version", config.ProtocolVersion, "addr", myAddress,
+		"ui", fmt.Sprintf("http://127.0.0.1:%d", settings.UIPort))
+	// Straight to the terminal rather than the log sinks, so the token
+	// doesn't end up in log files
+	fmt.Printf("🔑 Open the control UI: %s\n", auth.LoginURL())
+
+	n.StartBackground(settings.Network.StartupDelay.D())
+	if identity == network.IdentityRotating {
+		if _, ok := transport.(network.Rotator); ok {
+			slog.Info("🔄 Rotating identity", "every", *rotateEvery)
+			n.StartRotation(*rotateEvery, *rotateGrace)
+		} else {
+			slog.Warn("⚠️  Transport cannot rotate identities; staying ephemeral")
 		}
-	}()
+	}
 
-	log.Fatal(http.Serve(onion, versionMiddleware(mux)))
-}
\ No newline at end of file
+	logging.Fatal("❌ Onion service stopped", "err", n.Serve(n.Handler()))
+}
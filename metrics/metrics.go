@@ -0,0 +1,273 @@
+// Package metrics is a small registry of counters, gauges and histograms
+// served in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics by name. It is an http.Handler for /metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is where the New* constructors register
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+type metric interface {
+	header() (name, help, kind string)
+	write(w *bufio.Writer)
+}
+
+// register adds m, replacing any metric of the same name
+func (r *Registry) register(m metric) {
+	name, _, _ := m.header()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] = m
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, len(names))
+	for i, name := range names {
+		ms[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range ms {
+		name, help, kind := m.header()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, kind)
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type desc struct {
+	name, help string
+	labels     []string
+}
+
+// series renders name{labels} for one set of label values
+func (d desc) series(suffix string, values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return d.name + suffix
+	}
+	return d.name + suffix + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// values is a float per label set, shared by counters and gauges
+type values struct {
+	desc
+	kind string
+	mu   sync.Mutex
+	vals map[string]float64
+	sets map[string][]string
+}
+
+func (v *values) header() (string, string, string) { return v.name, v.help, v.kind }
+
+func (v *values) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.sets[key]; !ok {
+		v.sets[key] = append([]string(nil), labelValues...)
+	}
+	v.vals[key] += delta
+}
+
+func (v *values) set(x float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sets[key] = append([]string(nil), labelValues...)
+	v.vals[key] = x
+}
+
+func (v *values) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.vals))
+	for k := range v.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 && len(v.labels) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+	}
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %s\n", v.series("", v.sets[k]), formatValue(v.vals[k]))
+	}
+}
+
+func newValues(kind, name, help string, labels []string) *values {
+	return &values{desc: desc{name, help, labels}, kind: kind, vals: make(map[string]float64), sets: make(map[string][]string)}
+}
+
+// Counter only goes up. Label values are passed in the order the labels
+// were declared.
+type Counter struct{ v *values }
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newValues("counter", name, help, labels)}
+	Default.register(c.v)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.v.add(1, labelValues) }
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.v.name + " can't go down")
+	}
+	c.v.add(delta, labelValues)
+}
+
+// Gauge goes up and down
+type Gauge struct{ v *values }
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newValues("gauge", name, help, labels)}
+	Default.register(g.v)
+	return g
+}
+
+func (g *Gauge) Set(x float64, labelValues ...string) { g.v.set(x, labelValues) }
+func (g *Gauge) Inc(labelValues ...string)            { g.v.add(1, labelValues) }
+func (g *Gauge) Dec(labelValues ...string)            { g.v.add(-1, labelValues) }
+
+// funcMetric reads its value when scraped
+type funcMetric struct {
+	desc
+	kind string
+	fn   func() float64
+}
+
+func (f *funcMetric) header() (string, string, string) { return f.name, f.help, f.kind }
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.fn()))
+}
+
+// GaugeFunc registers a gauge read from fn on every scrape
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc{name: name, help: help}, "gauge", fn})
+}
+
+// CounterFunc registers a counter read from fn, which must never decrease
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc{name: name, help: help}, "counter", fn})
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histSeries
+}
+
+type histSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram takes the bucket upper bounds in increasing order
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, series: make(map[string]*histSeries)}
+	if len(labels) == 0 {
+		// Show the empty series before the first observation
+		h.series[""] = &histSeries{counts: make([]uint64, len(buckets))}
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) header() (string, string, string) { return h.name, h.help, "histogram" }
+
+func (h *Histogram) Observe(x float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, x); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += x
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s %d\n", h.desc.series("_bucket", s.labels, "le", formatValue(le)), cum)
+		}
+		fmt.Fprintf(w, "%s %d\n", h.desc.series("_bucket", s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.desc.series("_sum", s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.desc.series("_count", s.labels), s.count)
+	}
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const bundleFormat = "onivex-identity"
+
+// IdentityBundle is the portable export of an identity key. The key is
+// always sealed with the export passphrase, independent of how it is
+// stored in data/.
+type IdentityBundle struct {
+	Format  string   `json:"format"`
+	Version int      `json:"version"`
+	Address string   `json:"address"`
+	Key     *KeyData `json:"key"`
+}
+
+// ExportBundle seals priv into a JSON bundle under passphrase
+func ExportBundle(priv ed25519.PrivateKey, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("an export passphrase is required")
+	}
+	k, err := SealKey(priv, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(IdentityBundle{
+		Format:  bundleFormat,
+		Version: 1,
+		Address: OnionAddress(priv),
+		Key:     k,
+	}, "", "  ")
+}
+
+// ReadBundle parses a bundle without decrypting it. Its Address is only a
+// claim until Open has checked it against the key.
+func ReadBundle(data []byte) (*IdentityBundle, error) {
+	var b IdentityBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("not an identity bundle: %w", err)
+	}
+	if b.Format != bundleFormat || b.Key == nil {
+		return nil, fmt.Errorf("not an identity bundle")
+	}
+	if b.Version != 1 {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.Key.Encryption == nil {
+		return nil, fmt.Errorf("bundle key is not encrypted")
+	}
+	if err := b.Key.Encryption.checkParams(); err != nil {
+		return nil, fmt.Errorf("bad bundle: %w", err)
+	}
+	return &b, nil
+}
+
+// Open decrypts the bundle and checks the key matches its stated address
+func (b *IdentityBundle) Open(passphrase string) (ed25519.PrivateKey, error) {
+	priv, err := OpenKey(b.Key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if b.Address != "" && OnionAddress(priv) != b.Address {
+		return nil, fmt.Errorf("bundle key does not match address %s", b.Address)
+	}
+	return priv, nil
+}
+
+// KeyMnemonic encodes the 32-byte key seed as 34 words: one per byte plus
+// two checksum words so a mistyped word is caught on import. The mnemonic
+// is the key itself, so it must be kept as secret as the key file.
+func KeyMnemonic(priv ed25519.PrivateKey) string {
+	seed := priv.Seed()
+	sum := sha256.Sum256(seed)
+	words := make([]string, 0, len(seed)+2)
+	for _, b := range append(seed, sum[0], sum[1]) {
+		words = append(words, mnemonicWords[b])
+	}
+	return strings.Join(words, " ")
+}
+
+// KeyFromMnemonic reverses KeyMnemonic
+func KeyFromMnemonic(mnemonic string) (ed25519.PrivateKey, error) {
+	index := make(map[string]byte, len(mnemonicWords))
+	for i, w := range mnemonicWords {
+		index[w] = byte(i)
+	}
+
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != ed25519.SeedSize+2 {
+		return nil, fmt.Errorf("mnemonic has %d words, want %d", len(words), ed25519.SeedSize+2)
+	}
+	raw := make([]byte, len(words))
+	for i, w := range words {
+		b, ok := index[w]
+		if !ok {
+			return nil, fmt.Errorf("word %d (%q) is not in the word list", i+1, w)
+		}
+		raw[i] = b
+	}
+
+	seed := raw[:ed25519.SeedSize]
+	sum := sha256.Sum256(seed)
+	if raw[ed25519.SeedSize] != sum[0] || raw[ed25519.SeedSize+1] != sum[1] {
+		return nil, fmt.Errorf("mnemonic checksum mismatch; check the words for typos")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
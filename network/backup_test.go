@@ -0,0 +1,43 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+func TestReadBundleRejectsBadArgonParams(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	data, err := ExportBundle(priv, "correct horse")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	var b IdentityBundle
+	json.Unmarshal(data, &b)
+	b.Key.Encryption.Threads = 0
+	crafted, _ := json.Marshal(b)
+	if _, err := ReadBundle(crafted); err == nil {
+		t.Fatal("ReadBundle accepted a bundle with zero argon2 threads")
+	}
+}
+
+func TestBundleOpenChecksAddress(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	data, err := ExportBundle(priv, "correct horse")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	b, err := ReadBundle(data)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := b.Open("correct horse"); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	b.Address = OnionAddress(other)
+	if _, err := b.Open("correct horse"); err == nil {
+		t.Fatal("Open accepted a bundle whose address doesn't match its key")
+	}
+}
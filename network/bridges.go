@@ -0,0 +1,160 @@
+package network
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// knownTransports are the pluggable transports Onivex knows how to vet.
+// "meek" is accepted as an alias for lyrebird's meek_lite.
+var knownTransports = map[string]bool{
+	"obfs4":     true,
+	"snowflake": true,
+	"meek":      true,
+	"meek_lite": true,
+	"webtunnel": true,
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// LoadBridgeFile reads bridge lines from a file, one per line. Blank lines,
+// comments and a leading "Bridge " keyword (as copied from torrc or
+// bridges.torproject.org) are tolerated.
+func LoadBridgeFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(strings.TrimPrefix(line, "Bridge ")))
+	}
+	return lines, sc.Err()
+}
+
+// ValidateBridges checks bridge lines and transport plugins before they are
+// handed to tor, so mistakes surface as a clear error instead of a tor that
+// never finishes bootstrapping.
+func ValidateBridges(opts TorOptions) error {
+	plugins := map[string]bool{}
+	for _, spec := range opts.TransportPlugins {
+		names, path, err := parsePluginSpec(spec)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("transport plugin %s: %w", path, err)
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return fmt.Errorf("transport plugin %s is not executable", path)
+		}
+		for _, n := range names {
+			plugins[n] = true
+		}
+	}
+
+	if opts.UseBridges && len(opts.Bridges) == 0 {
+		return fmt.Errorf("bridges enabled but no bridge lines given")
+	}
+	for _, line := range opts.Bridges {
+		transport, err := parseBridgeLine(line)
+		if err != nil {
+			return fmt.Errorf("bridge %q: %w", line, err)
+		}
+		if transport != "" && !plugins[transport] {
+			return fmt.Errorf("bridge %q uses %s but no transport plugin provides it", line, transport)
+		}
+	}
+	return nil
+}
+
+// parseBridgeLine validates "[transport] host:port [fingerprint] [k=v ...]"
+// and returns the transport name ("" for vanilla bridges).
+func parseBridgeLine(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty bridge line")
+	}
+
+	transport := ""
+	if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+		transport = normalizeTransport(fields[0])
+		if !knownTransports[transport] {
+			return "", fmt.Errorf("unknown transport %q", fields[0])
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("missing address")
+	}
+	if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+		return "", fmt.Errorf("bad address %q", fields[0])
+	}
+	if len(fields) > 1 && !strings.Contains(fields[1], "=") {
+		if fp, err := hex.DecodeString(fields[1]); err != nil || len(fp) != 20 {
+			return "", fmt.Errorf("bad fingerprint %q", fields[1])
+		}
+	}
+	return transport, nil
+}
+
+// parsePluginSpec reads "obfs4,meek_lite=/usr/bin/lyrebird"
+func parsePluginSpec(spec string) ([]string, string, error) {
+	list, path, ok := strings.Cut(spec, "=")
+	if !ok || list == "" || path == "" {
+		return nil, "", fmt.Errorf("transport plugin %q: want name[,name]=/path/to/binary", spec)
+	}
+	names := []string{}
+	for _, n := range strings.Split(list, ",") {
+		n = normalizeTransport(strings.TrimSpace(n))
+		if !knownTransports[n] {
+			return nil, "", fmt.Errorf("transport plugin %q: unknown transport %q", spec, n)
+		}
+		names = append(names, n)
+	}
+	return names, path, nil
+}
+
+func normalizeTransport(name string) string {
+	name = strings.ToLower(name)
+	if name == "meek" {
+		return "meek_lite"
+	}
+	return name
+}
+
+// bridgeArgs turns the options into tor command line arguments
+func bridgeArgs(opts TorOptions) []string {
+	args := []string{}
+	for _, spec := range opts.TransportPlugins {
+		names, path, _ := parsePluginSpec(spec)
+		args = append(args, "--ClientTransportPlugin", strings.Join(names, ",")+" exec "+path)
+	}
+	if opts.UseBridges || len(opts.Bridges) > 0 {
+		args = append(args, "--UseBridges", "1")
+		for _, line := range opts.Bridges {
+			fields := strings.Fields(line)
+			if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+				fields[0] = normalizeTransport(fields[0])
+			}
+			args = append(args, "--Bridge", strings.Join(fields, " "))
+		}
+	}
+	return args
+}
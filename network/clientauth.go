@@ -0,0 +1,256 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cretz/bine/control"
+	"github.com/cretz/bine/tor"
+	bineed25519 "github.com/cretz/bine/torutil/ed25519"
+	"golang.org/x/crypto/curve25519"
+)
+
+// torBase32 is the unpadded base32 tor uses for x25519 keys in
+// authorized_clients and .auth_private files
+var torBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ClientAuth is the v3 onion client authorization store, kept in
+// data/client_auth.json. Authorized members may reach our onion service;
+// PrivateKey is our own credential, presented to every onion we dial unless
+// Services names a different key for it. With no Authorized entries our
+// service stays public.
+type ClientAuth struct {
+	PrivateKey string            `json:"private_key,omitempty"`
+	Authorized map[string]string `json:"authorized,omitempty"`
+	Services   map[string]string `json:"services,omitempty"`
+
+	path string
+
+	mu         sync.Mutex
+	registered map[string]bool
+}
+
+// DefaultClientAuthPath is ./data/client_auth.json
+func DefaultClientAuthPath() string {
+	cwd, _ := os.Getwd()
+	return filepath.Join(cwd, "data", "client_auth.json")
+}
+
+// LoadClientAuth reads the store at path; a missing file is an empty store
+func LoadClientAuth(path string) (*ClientAuth, error) {
+	ca := &ClientAuth{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ca, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ca); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, pub := range ca.Authorized {
+		if _, err := decodeX25519(pub); err != nil {
+			return nil, fmt.Errorf("authorized client %q: %w", name, err)
+		}
+	}
+	return ca, nil
+}
+
+// Save writes the store back to the file it was loaded from
+func (ca *ClientAuth) Save() error {
+	data, _ := json.MarshalIndent(ca, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(ca.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(ca.path, data, 0600)
+}
+
+// Private reports whether our onion service requires client authorization
+func (ca *ClientAuth) Private() bool { return ca != nil && len(ca.Authorized) > 0 }
+
+// PublicKey is the base32 x25519 key to give to the operators of private
+// nodes we want to reach
+func (ca *ClientAuth) PublicKey() (string, error) {
+	priv, err := decodeX25519(ca.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return torBase32.EncodeToString(pub), nil
+}
+
+// GenerateAuthKey creates a new x25519 client key pair, base32 encoded
+func GenerateAuthKey() (priv, pub string, err error) {
+	key := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+	pubKey, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return torBase32.EncodeToString(key), torBase32.EncodeToString(pubKey), nil
+}
+
+// ValidateAuthKey checks a base32 x25519 key as printed by `onivex auth init`
+// (tor's "descriptor:x25519:" prefix is accepted too)
+func ValidateAuthKey(s string) error {
+	_, err := decodeX25519(s)
+	return err
+}
+
+func decodeX25519(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "descriptor:"), "x25519:")
+	key, err := torBase32.DecodeString(strings.ToUpper(s))
+	if err != nil || len(key) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("not a base32 x25519 key")
+	}
+	return key, nil
+}
+
+// authorizedKeys returns the member keys in a stable order
+func (ca *ClientAuth) authorizedKeys() []string {
+	names := make([]string, 0, len(ca.Authorized))
+	for name := range ca.Authorized {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		key, _ := decodeX25519(ca.Authorized[name])
+		keys = append(keys, torBase32.EncodeToString(key))
+	}
+	return keys
+}
+
+// register hands tor our credential for the onion in addr ("<id>.onion:80")
+// so it can decrypt that service's descriptor. Each onion is registered once
+// per run; services that aren't private simply ignore the credential.
+func (ca *ClientAuth) register(t *tor.Tor, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	id := strings.TrimSuffix(strings.ToLower(host), ".onion")
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.registered[id] {
+		return nil
+	}
+
+	keyStr := ca.PrivateKey
+	if k, ok := ca.Services[id+".onion"]; ok {
+		keyStr = k
+	}
+	if keyStr == "" {
+		return nil
+	}
+	key, err := decodeX25519(keyStr)
+	if err != nil {
+		return fmt.Errorf("client auth key for %s: %w", id, err)
+	}
+
+	if _, err := t.Control.SendRequest("ONION_CLIENT_AUTH_ADD %s x25519:%s", id, base64.StdEncoding.EncodeToString(key)); err != nil {
+		return fmt.Errorf("tor rejected client auth for %s: %w", id, err)
+	}
+	if ca.registered == nil {
+		ca.registered = make(map[string]bool)
+	}
+	ca.registered[id] = true
+	return nil
+}
+
+// listenPrivateOnion is listenOnion for services with v3 client auth. bine's
+// ListenConf only knows the v2 BasicAuth scheme, so ADD_ONION is sent by
+// hand and the OnionService assembled the way Listen would.
+func listenPrivateOnion(ctx context.Context, t *tor.Tor, key ed25519.PrivateKey, auth *ClientAuth) (*tor.OnionService, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	kp := bineed25519.FromCryptoPrivateKey(key)
+	svc := &tor.OnionService{
+		Tor:                       t,
+		Key:                       kp,
+		Version3:                  true,
+		LocalListener:             ln,
+		RemotePorts:               []int{80},
+		CloseLocalListenerOnClose: true,
+	}
+
+	cmd := fmt.Sprintf("ADD_ONION ED25519-V3:%s Flags=V3Auth Port=80,%s",
+		(&control.ED25519Key{KeyPair: kp}).Blob(), ln.Addr().String())
+	for _, pub := range auth.authorizedKeys() {
+		cmd += " ClientAuthV3=" + pub
+	}
+	resp, err := t.Control.SendRequest("%s", cmd)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("ADD_ONION with client auth failed (tor 0.4.6+ required): %w", err)
+	}
+	for _, line := range resp.Data {
+		if id, ok := strings.CutPrefix(line, "ServiceID="); ok {
+			svc.ID = id
+		}
+	}
+	if svc.ID == "" {
+		ln.Close()
+		return nil, fmt.Errorf("ADD_ONION returned no service ID")
+	}
+
+	if err := waitPublished(ctx, t, svc.ID); err != nil {
+		svc.Close()
+		return nil, err
+	}
+	return svc, nil
+}
+
+// waitPublished blocks until at least one HSDir accepted the descriptor for
+// id, mirroring what bine's Listen does. Only a tor we started gets its
+// network enabled; an attached system tor is left as its operator set it.
+func waitPublished(ctx context.Context, t *tor.Tor, id string) error {
+	if !attached(t) {
+		if err := t.EnableNetwork(ctx, true); err != nil {
+			return err
+		}
+	}
+	uploads, failures := 0, 0
+	_, err := t.Control.EventWait(ctx, []control.EventCode{control.EventCodeHSDesc},
+		func(evt control.Event) (bool, error) {
+			hs, _ := evt.(*control.HSDescEvent)
+			if hs == nil || hs.Address != id {
+				return false, nil
+			}
+			switch hs.Action {
+			case "UPLOAD":
+				uploads++
+			case "FAILED":
+				if failures++; failures == uploads {
+					return false, fmt.Errorf("all descriptor uploads failed")
+				}
+			case "UPLOADED":
+				return true, nil
+			}
+			return false, nil
+		})
+	return err
+}
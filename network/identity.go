@@ -0,0 +1,111 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdentityMode controls how long a node keeps its onion address
+type IdentityMode string
+
+const (
+	// IdentityPersistent loads the same key from data/ on every start
+	IdentityPersistent IdentityMode = "persistent"
+	// IdentityEphemeral uses a fresh key per run that is never written to disk
+	IdentityEphemeral IdentityMode = "ephemeral"
+	// IdentityRotating starts ephemeral and replaces the key on a schedule
+	IdentityRotating IdentityMode = "rotating"
+)
+
+// ClientKeyName is the key of a client's persistent identity, data/client_identity.key
+const ClientKeyName = "client_identity"
+
+// ParseIdentityMode accepts the -identity flag values
+func ParseIdentityMode(s string) (IdentityMode, error) {
+	switch m := IdentityMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case IdentityPersistent, IdentityEphemeral, IdentityRotating:
+		return m, nil
+	case "":
+		return IdentityPersistent, nil
+	default:
+		return "", fmt.Errorf("unknown identity mode %q (want persistent, ephemeral or rotating)", s)
+	}
+}
+
+// KeyName returns the key name to hand to OpenTransport: name for
+// persistent identities and "" (ephemeral key) otherwise.
+func (m IdentityMode) KeyName(name string) string {
+	if m == IdentityPersistent || m == "" {
+		return name
+	}
+	return ""
+}
+
+// Rotator is implemented by transports that can switch to a new identity
+// without dropping connections made to the old one.
+type Rotator interface {
+	// Rotate publishes a new address and returns it. The previous address
+	// keeps accepting connections for grace before it is torn down.
+	Rotate(grace time.Duration) (string, error)
+}
+
+// multiListener merges the accept loops of several listeners (the current
+// onion service plus any that are being retired) into one net.Listener.
+// Closing it does not close the listeners it was fed; their owner does that.
+type multiListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	addr net.Addr
+}
+
+func newMultiListener() *multiListener {
+	return &multiListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// add starts accepting from l; the newest listener provides Addr()
+func (m *multiListener) add(l net.Listener) {
+	m.mu.Lock()
+	m.addr = l.Addr()
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case m.conns <- conn:
+			case <-m.closed:
+				conn.Close()
+				return
+			}
+		}
+	}()
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	m.once.Do(func() { close(m.closed) })
+	return nil
+}
+
+func (m *multiListener) Addr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addr
+}
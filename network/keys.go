@@ -2,49 +2,292 @@ package network
 
 import (
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // KeyData represents the JSON structure of the saved key
 type KeyData struct {
 	Type       string `json:"type"`
-	PrivateKey string `json:"private_key"`
+	PrivateKey string `json:"private_key,omitempty"`
+	// Encryption replaces PrivateKey when the key is passphrase protected
+	Encryption *KeyEncryption `json:"encryption,omitempty"`
 }
 
-// LoadOrGenerateKey retrieves a key from disk or creates a new one
-func LoadOrGenerateKey(name string) (ed25519.PrivateKey, error) {
+// KeyEncryption is a private key sealed with XChaCha20-Poly1305 under an
+// Argon2id-derived key. The parameters are stored so they can be raised
+// later without breaking old files.
+type KeyEncryption struct {
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory_kib"`
+	Threads    uint8  `json:"threads"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+const (
+	kdfArgon2id       = "argon2id"
+	cipherXChaCha20   = "xchacha20-poly1305"
+	argonTime         = 3
+	argonMemory       = 64 * 1024
+	argonThreads      = 4
+	passphraseEnv     = "ONIVEX_KEY_PASSPHRASE"
+	minPassphraseSize = 8
+
+	// Bounds on the stored Argon2 parameters, so a corrupt or crafted file
+	// can't crash argon2 or make it allocate without limit
+	argonMaxTime      = 64
+	argonMinMemoryKiB = 8 * 1024
+	argonMaxMemoryKiB = 4 * 1024 * 1024
+)
+
+// ErrWrongPassphrase is returned when an encrypted key fails to open
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// KeyPath is where the named key lives: ./data/name.key
+func KeyPath(name string) string {
 	cwd, _ := os.Getwd()
-	// Keys are stored in ./data/name.key
-	keyPath := filepath.Join(cwd, "data", name+".key")
+	return filepath.Join(cwd, "data", name+".key")
+}
 
+// LoadKey reads the named key, asking for the passphrase if it is
+// encrypted. A missing file is reported as an os.ErrNotExist error.
+func LoadKey(name string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(KeyPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var k KeyData
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("%s is not a valid key file: %w", name, err)
+	}
+	if k.Encryption != nil {
+		return unlockKey(name, &k)
+	}
+	if Keys.Encrypt {
+		fmt.Fprintf(os.Stderr, "⚠️  %s is stored unencrypted; run `onivex key migrate` to protect it\n", name)
+	}
+	return decodePlainKey(name, &k)
+}
+
+// LoadOrGenerateKey retrieves a key from disk or creates a new one. Only a
+// missing file leads to a new key; a corrupt one is an error so the
+// identity is never silently replaced.
+func LoadOrGenerateKey(name string) (ed25519.PrivateKey, error) {
 	// 1. Try to load existing key
-	if data, err := os.ReadFile(keyPath); err == nil {
-		var k KeyData
-		if err := json.Unmarshal(data, &k); err == nil {
-			decoded, _ := base64.StdEncoding.DecodeString(k.PrivateKey)
-			return ed25519.PrivateKey(decoded), nil
-		}
+	priv, err := LoadKey(name)
+	if err == nil {
+		return priv, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
 	// 2. Generate new key if none exists
-	_, priv, err := ed25519.GenerateKey(nil)
+	_, priv, err = ed25519.GenerateKey(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// 3. Save key to disk
-	k := KeyData{
-		Type:       "ed25519",
-		PrivateKey: base64.StdEncoding.EncodeToString(priv),
+	// 3. Save key to disk, sealed if a passphrase is configured
+	var pass string
+	if Keys.WantsEncryption() {
+		if pass, err = Keys.Passphrase(name, true); err != nil {
+			return nil, err
+		}
 	}
-	jsonData, _ := json.MarshalIndent(k, "", "  ")
+	if err := SaveKey(name, priv, pass); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
 
-	// Ensure data dir exists
-	os.MkdirAll(filepath.Join(cwd, "data"), 0700)
-	os.WriteFile(keyPath, jsonData, 0600)
+func decodePlainKey(name string, k *KeyData) (ed25519.PrivateKey, error) {
+	if k.Type != "" && k.Type != "ed25519" {
+		return nil, fmt.Errorf("%s: unsupported key type %q", name, k.Type)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad key encoding: %w", name, err)
+	}
+	if len(decoded) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s: key has wrong size %d", name, len(decoded))
+	}
+	// The second half is the public key; a mismatch means bit rot
+	if !ed25519.NewKeyFromSeed(decoded[:ed25519.SeedSize]).Equal(ed25519.PrivateKey(decoded)) {
+		return nil, fmt.Errorf("%s: key is corrupt (public half does not match)", name)
+	}
+	return ed25519.PrivateKey(decoded), nil
+}
 
+func unlockKey(name string, k *KeyData) (ed25519.PrivateKey, error) {
+	pass, err := Keys.Passphrase(name, false)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := OpenKey(k, pass)
+	if err != nil {
+		return nil, fmt.Errorf("could not unlock %s: %w", name, err)
+	}
 	return priv, nil
-}
\ No newline at end of file
+}
+
+// SaveKey writes the named key, encrypted when passphrase is non-empty. The
+// file is replaced atomically so an interrupted write can't lose the key.
+func SaveKey(name string, priv ed25519.PrivateKey, passphrase string) error {
+	k, err := SealKey(priv, passphrase)
+	if err != nil {
+		return err
+	}
+	jsonData, _ := json.MarshalIndent(k, "", "  ")
+
+	keyPath := KeyPath(name)
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return err
+	}
+	tmp := keyPath + ".tmp"
+	if err := os.WriteFile(tmp, jsonData, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, keyPath)
+}
+
+// SealKey builds the on-disk form of priv; an empty passphrase stores it in
+// plaintext as older versions did.
+func SealKey(priv ed25519.PrivateKey, passphrase string) (*KeyData, error) {
+	if passphrase == "" {
+		return &KeyData{Type: "ed25519", PrivateKey: base64.StdEncoding.EncodeToString(priv)}, nil
+	}
+
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	enc := &KeyEncryption{
+		KDF:     kdfArgon2id,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+		Cipher:  cipherXChaCha20,
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+	}
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, enc.Time, enc.Memory, enc.Threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	enc.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, priv, []byte("ed25519")))
+	return &KeyData{Type: "ed25519", Encryption: enc}, nil
+}
+
+// checkParams rejects Argon2 parameters outside the bounds we accept
+func (enc *KeyEncryption) checkParams() error {
+	switch {
+	case enc.Time < 1 || enc.Time > argonMaxTime:
+		return fmt.Errorf("argon2 time %d is out of range 1-%d", enc.Time, argonMaxTime)
+	case enc.Threads < 1:
+		return fmt.Errorf("argon2 threads must be at least 1")
+	case enc.Memory < argonMinMemoryKiB || enc.Memory > argonMaxMemoryKiB:
+		return fmt.Errorf("argon2 memory %d KiB is out of range %d-%d", enc.Memory, argonMinMemoryKiB, argonMaxMemoryKiB)
+	}
+	return nil
+}
+
+// OpenKey decrypts a sealed KeyData
+func OpenKey(k *KeyData, passphrase string) (ed25519.PrivateKey, error) {
+	enc := k.Encryption
+	if enc == nil {
+		return nil, fmt.Errorf("key is not encrypted")
+	}
+	if enc.KDF != kdfArgon2id || enc.Cipher != cipherXChaCha20 {
+		return nil, fmt.Errorf("unsupported key encryption %s/%s", enc.KDF, enc.Cipher)
+	}
+	if err := enc.checkParams(); err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("bad salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(enc.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("bad nonce")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(enc.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("bad ciphertext: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, enc.Time, enc.Memory, enc.Threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, sealed, []byte(k.Type))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(plain) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("decrypted key has wrong size %d", len(plain))
+	}
+	return ed25519.PrivateKey(plain), nil
+}
+
+// MigrateKey re-saves a plaintext key file encrypted with passphrase. Keys
+// that are already encrypted are left alone.
+func MigrateKey(name, passphrase string) (bool, error) {
+	data, err := os.ReadFile(KeyPath(name))
+	if err != nil {
+		return false, err
+	}
+	var k KeyData
+	if err := json.Unmarshal(data, &k); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	if k.Encryption != nil {
+		return false, nil
+	}
+	priv, err := decodePlainKey(name, &k)
+	if err != nil {
+		return false, err
+	}
+	return true, SaveKey(name, priv, passphrase)
+}
+
+// KeyEncrypted reports whether the named key file is passphrase protected
+func KeyEncrypted(name string) (bool, error) {
+	data, err := os.ReadFile(KeyPath(name))
+	if err != nil {
+		return false, err
+	}
+	var k KeyData
+	if err := json.Unmarshal(data, &k); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return k.Encryption != nil, nil
+}
+
+// ListKeys returns the names of the key files in ./data
+func ListKeys() []string {
+	matches, _ := filepath.Glob(KeyPath("*"))
+	names := []string{}
+	for _, m := range matches {
+		names = append(names, filepath.Base(m[:len(m)-len(".key")]))
+	}
+	return names
+}
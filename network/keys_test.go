@@ -0,0 +1,51 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestOpenKeyRoundTrip(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	k, err := SealKey(priv, "correct horse")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	got, err := OpenKey(k, "correct horse")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !got.Equal(priv) {
+		t.Fatal("opened key differs from sealed key")
+	}
+	if _, err := OpenKey(k, "wrong horse"); err != ErrWrongPassphrase {
+		t.Fatalf("wrong passphrase: got %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestOpenKeyRejectsBadArgonParams(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	tests := []struct {
+		name string
+		edit func(*KeyEncryption)
+	}{
+		{"zero time", func(e *KeyEncryption) { e.Time = 0 }},
+		{"huge time", func(e *KeyEncryption) { e.Time = 1 << 30 }},
+		{"zero threads", func(e *KeyEncryption) { e.Threads = 0 }},
+		{"tiny memory", func(e *KeyEncryption) { e.Memory = 0 }},
+		{"huge memory", func(e *KeyEncryption) { e.Memory = 1<<32 - 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := SealKey(priv, "correct horse")
+			if err != nil {
+				t.Fatalf("seal: %v", err)
+			}
+			tt.edit(k.Encryption)
+			if _, err := OpenKey(k, "correct horse"); err == nil {
+				t.Fatal("OpenKey accepted out-of-range argon2 parameters")
+			}
+		})
+	}
+}
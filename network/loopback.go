@@ -0,0 +1,182 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LoopbackNetwork maps onion-style addresses to plain TCP listeners on
+// 127.0.0.1, so a mesh can run on one machine without Tor. With an empty Dir
+// the registry is in-process only; with a Dir, nodes in separate processes
+// find each other through one file per address.
+type LoopbackNetwork struct {
+	Dir string
+
+	mu        sync.RWMutex
+	addrs     map[string]string
+	reachable func(from, to string) bool
+	conns     map[*loopbackConn]struct{}
+}
+
+// DefaultLoopback is shared by every process on the machine via a temp dir
+var DefaultLoopback = NewLoopbackNetwork(filepath.Join(os.TempDir(), "onivex-loopback"))
+
+func NewLoopbackNetwork(dir string) *LoopbackNetwork {
+	if dir != "" {
+		os.MkdirAll(dir, 0700)
+	}
+	return &LoopbackNetwork{Dir: dir, addrs: make(map[string]string), conns: make(map[*loopbackConn]struct{})}
+}
+
+// SetReachable installs a reachability rule used to simulate partitions
+// (nil means fully connected). Open connections the new rule forbids are
+// closed so pooled keep-alives can't leak across the partition.
+func (n *LoopbackNetwork) SetReachable(fn func(from, to string) bool) {
+	n.mu.Lock()
+	n.reachable = fn
+	doomed := []*loopbackConn{}
+	for c := range n.conns {
+		if fn != nil && !fn(c.from, c.to) {
+			doomed = append(doomed, c)
+		}
+	}
+	n.mu.Unlock()
+	for _, c := range doomed {
+		c.Close()
+	}
+}
+
+func (n *LoopbackNetwork) canReach(from, to string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.reachable == nil || n.reachable(from, to)
+}
+
+// Listen opens a loopback listener whose address is derived from key exactly
+// as Tor would derive the v3 onion address.
+func (n *LoopbackNetwork) Listen(key ed25519.PrivateKey) (*LoopbackTransport, error) {
+	if key == nil {
+		var err error
+		if _, key, err = ed25519.GenerateKey(nil); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	t := &LoopbackTransport{Network: n, Key: key, addr: OnionAddress(key), ln: ln}
+	if err := n.register(t.addr, ln.Addr().String()); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	slog.Info("🔁 Loopback identity", "addr", t.addr, "listen", ln.Addr().String())
+	return t, nil
+}
+
+func (n *LoopbackNetwork) register(onion, hostport string) error {
+	n.mu.Lock()
+	n.addrs[onion] = hostport
+	n.mu.Unlock()
+	if n.Dir == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(n.Dir, onion), []byte(hostport), 0600)
+}
+
+func (n *LoopbackNetwork) unregister(onion string) {
+	n.mu.Lock()
+	delete(n.addrs, onion)
+	n.mu.Unlock()
+	if n.Dir != "" {
+		os.Remove(filepath.Join(n.Dir, onion))
+	}
+}
+
+// Resolve returns the 127.0.0.1 address currently serving an onion
+func (n *LoopbackNetwork) Resolve(onion string) (string, bool) {
+	n.mu.RLock()
+	hostport, ok := n.addrs[onion]
+	n.mu.RUnlock()
+	if ok || n.Dir == "" {
+		return hostport, ok
+	}
+	data, err := os.ReadFile(filepath.Join(n.Dir, filepath.Base(onion)))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// LoopbackTransport is a Transport backed by LoopbackNetwork
+type LoopbackTransport struct {
+	Network *LoopbackNetwork
+	Key     ed25519.PrivateKey
+
+	addr string
+	ln   net.Listener
+}
+
+func (t *LoopbackTransport) Address() string { return t.addr }
+
+func (t *LoopbackTransport) Listener() net.Listener { return t.ln }
+
+// Sign implements Signer
+func (t *LoopbackTransport) Sign(msg []byte) []byte { return ed25519.Sign(t.Key, msg) }
+
+// DialContext ignores the requested port and connects to whichever local
+// listener registered the onion host.
+func (t *LoopbackTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	hostport, ok := t.Network.Resolve(host)
+	if !ok || !t.Network.canReach(t.addr, host) {
+		return nil, fmt.Errorf("loopback: no route to %s", host)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+	lc := &loopbackConn{Conn: conn, from: t.addr, to: host, network: t.Network}
+	t.Network.mu.Lock()
+	t.Network.conns[lc] = struct{}{}
+	t.Network.mu.Unlock()
+	return lc, nil
+}
+
+// loopbackConn is tracked so partitions can cut established connections
+type loopbackConn struct {
+	net.Conn
+	from, to string
+	network  *LoopbackNetwork
+	once     sync.Once
+}
+
+func (c *loopbackConn) Close() error {
+	c.once.Do(func() {
+		c.network.mu.Lock()
+		delete(c.network.conns, c)
+		c.network.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// Bootstrap implements BootstrapReporter; loopback is ready immediately
+func (t *LoopbackTransport) Bootstrap() BootstrapStatus {
+	return BootstrapStatus{Progress: 100, Tag: "done", Summary: "Loopback (no Tor)"}
+}
+
+func (t *LoopbackTransport) Close() error {
+	t.Network.unregister(t.addr)
+	return t.ln.Close()
+}
@@ -0,0 +1,90 @@
+package network
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// KeyOptions says where a key passphrase comes from. Sources are tried in
+// order: file, the Env variable, then an interactive prompt if stdin is a
+// terminal.
+type KeyOptions struct {
+	// PassphraseFile holds the passphrase on its first line
+	PassphraseFile string
+	// Env names the environment variable to check
+	Env string
+	// Encrypt protects newly generated keys even without a file or env var
+	Encrypt bool
+}
+
+// Keys is consulted by LoadOrGenerateKey; RegisterKeyFlags fills it in
+var Keys = &KeyOptions{Env: passphraseEnv}
+
+// RegisterKeyFlags adds the key encryption flags to fs
+func RegisterKeyFlags(fs *flag.FlagSet) *KeyOptions {
+	fs.StringVar(&Keys.PassphraseFile, "key-passphrase-file", "", "File containing the identity key passphrase (or set "+passphraseEnv+")")
+	fs.BoolVar(&Keys.Encrypt, "encrypt-key", false, "Encrypt newly generated identity keys with a passphrase")
+	return Keys
+}
+
+// WantsEncryption reports whether new keys should be sealed
+func (o *KeyOptions) WantsEncryption() bool {
+	return o.Encrypt || o.PassphraseFile != "" || (o.Env != "" && os.Getenv(o.Env) != "")
+}
+
+// Passphrase fetches the passphrase for the named key. confirm asks twice
+// when prompting, for keys that are about to be (re-)encrypted.
+func (o *KeyOptions) Passphrase(name string, confirm bool) (string, error) {
+	if o.PassphraseFile != "" {
+		data, err := os.ReadFile(o.PassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read passphrase file: %w", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return checkPassphrase(strings.TrimRight(line, "\r"), confirm)
+	}
+	if o.Env != "" {
+		if env := os.Getenv(o.Env); env != "" {
+			return checkPassphrase(env, confirm)
+		}
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("%s needs a passphrase: use a passphrase file or set %s", name, o.Env)
+	}
+
+	pass, err := prompt(fmt.Sprintf("🔑 Passphrase for %s: ", name))
+	if err != nil || !confirm {
+		return pass, err
+	}
+	if _, err := checkPassphrase(pass, true); err != nil {
+		return "", err
+	}
+	again, err := prompt("🔑 Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != pass {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// checkPassphrase only enforces a minimum length when setting a passphrase;
+// existing keys open with whatever they were sealed with.
+func checkPassphrase(pass string, setting bool) (string, error) {
+	if setting && len(pass) < minPassphraseSize {
+		return "", fmt.Errorf("passphrase must be at least %d characters", minPassphraseSize)
+	}
+	return pass, nil
+}
+
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(pass), err
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cretz/bine/tor"
+)
+
+// BootstrapStatus is tor's latest "status/bootstrap-phase" report
+type BootstrapStatus struct {
+	Progress int    `json:"progress"`
+	Tag      string `json:"tag"`
+	Summary  string `json:"summary"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+// BootstrapReporter is implemented by transports that have a bootstrap
+// phase worth showing in the UI
+type BootstrapReporter interface {
+	Bootstrap() BootstrapStatus
+}
+
+var (
+	bootMu     sync.RWMutex
+	bootStatus = BootstrapStatus{Summary: "Starting"}
+)
+
+// CurrentBootstrap returns the last status seen by the progress watcher.
+// It is usable before SetupTor returns, e.g. by the web UI's boot screen.
+func CurrentBootstrap() BootstrapStatus {
+	bootMu.RLock()
+	defer bootMu.RUnlock()
+	return bootStatus
+}
+
+func setBootstrap(s BootstrapStatus) {
+	bootMu.Lock()
+	bootStatus = s
+	bootMu.Unlock()
+}
+
+// watchBootstrap polls tor until its control connection goes away
+func watchBootstrap(t *tor.Tor) {
+	go func() {
+		for {
+			if t.Control == nil {
+				return
+			}
+			info, err := t.Control.GetInfo("status/bootstrap-phase")
+			if err != nil {
+				return
+			}
+			if len(info) == 1 {
+				setBootstrap(parseBootstrapPhase(info[0].Val))
+			}
+			interval := time.Second
+			if CurrentBootstrap().Progress >= 100 {
+				interval = 30 * time.Second
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// parseBootstrapPhase reads `NOTICE BOOTSTRAP PROGRESS=45 TAG=x SUMMARY="..."`
+func parseBootstrapPhase(line string) BootstrapStatus {
+	s := BootstrapStatus{}
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		if i := strings.LastIndex(key, " "); i >= 0 {
+			key = key[i+1:]
+		}
+		var val string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				val, line = rest[1:], ""
+			} else {
+				val, line = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			val, line, _ = strings.Cut(rest, " ")
+		}
+		switch key {
+		case "PROGRESS":
+			s.Progress, _ = strconv.Atoi(val)
+		case "TAG":
+			s.Tag = val
+		case "SUMMARY":
+			s.Summary = val
+		case "WARNING":
+			s.Warning = val
+		}
+	}
+	return s
+}
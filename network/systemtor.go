@@ -0,0 +1,108 @@
+package network
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/cretz/bine/control"
+	"github.com/cretz/bine/tor"
+)
+
+// TorOptions selects how Onivex gets hold of Tor. With no ControlAddr a
+// bundled Tor is started in data/tor; otherwise we attach to a running
+// daemon and leave it running on exit.
+type TorOptions struct {
+	// ControlAddr is "host:port" or "unix:/path/to/control.sock"
+	ControlAddr string
+	// ControlPassword is the HashedControlPassword secret, if used
+	ControlPassword string
+	// CookieFile overrides the cookie path tor reports in PROTOCOLINFO
+	CookieFile string
+	// SocksAddr overrides the SOCKS listener tor reports (host:port)
+	SocksAddr string
+
+	// UseBridges, Bridges and TransportPlugins configure a bundled tor for
+	// censored networks. TransportPlugins entries look like
+	// "obfs4,meek_lite=/usr/bin/lyrebird".
+	UseBridges       bool
+	Bridges          []string
+	TransportPlugins []string
+	// BridgeFile is read into Bridges by SetupTor
+	BridgeFile string
+
+	// ClientAuthFile is the v3 client auth store (default data/client_auth.json),
+	// loaded into ClientAuth by OpenTransport
+	ClientAuthFile string
+	ClientAuth     *ClientAuth
+}
+
+// RegisterTorFlags adds the system-tor flags to fs. The password may also
+// come from ONIVEX_TOR_PASSWORD so it doesn't show up in `ps`.
+func RegisterTorFlags(fs *flag.FlagSet) *TorOptions {
+	opts := &TorOptions{}
+	fs.StringVar(&opts.ControlAddr, "tor-control", "", "Attach to a running tor at this ControlPort (host:port) or ControlSocket (unix:/path) instead of starting one")
+	fs.StringVar(&opts.ControlPassword, "tor-password", os.Getenv("ONIVEX_TOR_PASSWORD"), "Control port password (or set ONIVEX_TOR_PASSWORD)")
+	fs.StringVar(&opts.CookieFile, "tor-cookie", "", "Control auth cookie file (default: the path tor reports)")
+	fs.StringVar(&opts.SocksAddr, "tor-socks", "", "SOCKS address of the running tor (default: the listener tor reports)")
+	fs.BoolVar(&opts.UseBridges, "tor-use-bridges", false, "Connect to Tor only through bridges")
+	fs.Var((*stringList)(&opts.Bridges), "tor-bridge", "Bridge line, e.g. \"obfs4 1.2.3.4:443 FINGERPRINT cert=... iat-mode=0\" (repeatable)")
+	fs.StringVar(&opts.BridgeFile, "tor-bridges-file", "", "File with one bridge line per line")
+	fs.StringVar(&opts.ClientAuthFile, "tor-client-auth", "", "Onion client authorization store (default data/client_auth.json)")
+	fs.Var((*stringList)(&opts.TransportPlugins), "tor-transport-plugin", "Pluggable transport binary, e.g. obfs4,meek_lite=/usr/bin/lyrebird or snowflake=/usr/bin/snowflake-client (repeatable)")
+	return opts
+}
+
+// AttachTor connects to an already running tor daemon and authenticates
+// with a password, an explicit cookie file, or whatever tor advertises.
+func AttachTor(opts TorOptions) (*tor.Tor, error) {
+	netw, addr := "tcp", opts.ControlAddr
+	if strings.HasPrefix(addr, "unix:") {
+		netw, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+
+	slog.Info("🔗 Attaching to system Tor", "control", opts.ControlAddr)
+	textConn, err := textproto.Dial(netw, addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach tor control port: %w", err)
+	}
+	conn := control.NewConn(textConn)
+
+	if err := authenticateControl(conn, opts); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tor control auth failed: %w", err)
+	}
+
+	// StopProcessOnClose stays false: Close only drops our control
+	// connection (which also removes our non-detached onion services).
+	return &tor.Tor{Control: conn}, nil
+}
+
+func authenticateControl(conn *control.Conn, opts TorOptions) error {
+	switch {
+	case opts.ControlPassword != "":
+		if _, err := conn.SendRequest("AUTHENTICATE %s", hex.EncodeToString([]byte(opts.ControlPassword))); err != nil {
+			return err
+		}
+	case opts.CookieFile != "":
+		cookie, err := os.ReadFile(opts.CookieFile)
+		if err != nil {
+			return err
+		}
+		if len(cookie) != 32 {
+			return fmt.Errorf("invalid cookie file length %d", len(cookie))
+		}
+		if _, err := conn.SendRequest("AUTHENTICATE %s", hex.EncodeToString(cookie)); err != nil {
+			return err
+		}
+	default:
+		// NULL or SAFECOOKIE using the cookie path from PROTOCOLINFO
+		return conn.Authenticate("")
+	}
+	conn.Authenticated = true
+	return nil
+}
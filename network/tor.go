@@ -4,67 +4,251 @@ import (
 	"context"
 	"crypto/ed25519"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/cretz/bine/tor"
+	bineed25519 "github.com/cretz/bine/torutil/ed25519"
 )
 
-// SetupTor starts Tor.
-// If keyName is provided, it persists the onion address to disk (Seed Mode).
-// If keyName is empty "", it generates a fresh, temporary onion address (Client Mode).
-func SetupTor(keyName string) (*tor.Tor, *tor.OnionService, error) {
-	fmt.Println("🌱 Initializing Tor...")
+// SetupTor starts Tor, or attaches to a running daemon if opts.ControlAddr is set.
+// If keyName is provided, it persists the onion address to disk; if it is
+// empty, a fresh temporary onion address is generated. IdentityMode.KeyName
+// maps the -identity flag onto these two cases.
+func SetupTor(keyName string, opts TorOptions) (*tor.Tor, *tor.OnionService, error) {
+	// 1. Key Logic
+	privKey, err := identityKey(keyName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key generation failed: %w", err)
+	}
+
+	// 2. Bridges
+	if opts.BridgeFile != "" {
+		lines, err := LoadBridgeFile(opts.BridgeFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read bridges: %w", err)
+		}
+		opts.Bridges = append(opts.Bridges, lines...)
+	}
+	if err := ValidateBridges(opts); err != nil {
+		return nil, nil, err
+	}
+
+	// 3. Start or Attach Tor
+	var t *tor.Tor
+	if opts.ControlAddr != "" {
+		if len(opts.Bridges) > 0 || len(opts.TransportPlugins) > 0 {
+			slog.Warn("⚠️  Ignoring bridge settings: configure bridges in the system tor's torrc")
+		}
+		t, err = AttachTor(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		slog.Info("🌱 Initializing Tor")
+
+		cwd, _ := os.Getwd()
+		dataDir := filepath.Join(cwd, "data", "tor")
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			return nil, nil, fmt.Errorf("could not create data dir: %w", err)
+		}
+
+		conf := &tor.StartConf{
+			DataDir:     dataDir,
+			DebugWriter: os.Stdout,
+			ExtraArgs:   bridgeArgs(opts),
+		}
+		if len(opts.Bridges) > 0 {
+			slog.Info("🌉 Using bridges", "bridges", len(opts.Bridges))
+		}
+
+		// Using nil for config to download Tor executable automatically if not present
+		t, err = tor.Start(nil, conf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tor start failed: %w", err)
+		}
+	}
+
+	watchBootstrap(t)
 
-	// 1. Data Directory
-	cwd, _ := os.Getwd()
-	dataDir := filepath.Join(cwd, "data", "tor")
-	if err := os.MkdirAll(dataDir, 0700); err != nil {
-		return nil, nil, fmt.Errorf("could not create data dir: %w", err)
+	// 4. Create Onion Service
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	slog.Info("🧅 Creating/restoring v3 onion service")
+
+	if opts.ClientAuth.Private() {
+		slog.Info("🔒 Onion service restricted to authorized clients", "clients", len(opts.ClientAuth.Authorized))
+	}
+	onion, err := listenOnion(ctx, t, privKey, opts.ClientAuth)
+	if err != nil {
+		t.Close()
+		return nil, nil, fmt.Errorf("onion service failed: %w", err)
 	}
 
-	// 2. Key Logic
-	var privKey ed25519.PrivateKey
-	var err error
+	return t, onion, nil
+}
 
+// listenOnion publishes a v3 onion service for key on virtual port 80,
+// restricted to auth's members if it is private
+func listenOnion(ctx context.Context, t *tor.Tor, key ed25519.PrivateKey, auth *ClientAuth) (*tor.OnionService, error) {
+	if auth.Private() {
+		return listenPrivateOnion(ctx, t, key, auth)
+	}
+	// NoWait, because Listen's wait would SETCONF DisableNetwork=0, which
+	// isn't ours to change on a system tor
+	onion, err := t.Listen(ctx, &tor.ListenConf{
+		Version3:    true,
+		RemotePorts: []int{80},
+		Key:         key,
+		NoWait:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := waitPublished(ctx, t, onion.ID); err != nil {
+		onion.Close()
+		return nil, err
+	}
+	return onion, nil
+}
+
+// attached reports whether t is a system tor we attached to rather than
+// one we started. We never reconfigure an attached tor.
+func attached(t *tor.Tor) bool { return t.Process == nil }
+
+// identityKey loads the named persistent key, or makes an ephemeral one
+func identityKey(keyName string) (ed25519.PrivateKey, error) {
 	if keyName != "" {
 		// SEED MODE: Load or Create & Save
-		fmt.Printf("🔐 Loading persistent identity: %s\n", keyName)
-		privKey, err = LoadOrGenerateKey(keyName)
-	} else {
-		// CLIENT MODE: Generate Ephemeral Key (No Save)
-		fmt.Println("👻 Generating temporary anonymous identity...")
-		_, privKey, err = ed25519.GenerateKey(nil)
+		slog.Info("🔐 Loading persistent identity", "key", keyName)
+		return LoadOrGenerateKey(keyName)
 	}
+	// CLIENT MODE: Generate Ephemeral Key (No Save)
+	slog.Info("👻 Generating temporary anonymous identity")
+	_, privKey, err := ed25519.GenerateKey(nil)
+	return privKey, err
+}
 
-	if err != nil {
-		return nil, nil, fmt.Errorf("key generation failed: %w", err)
+// TorTransport is the Transport backed by a bine Tor instance and its onion
+// service. The SOCKS dialer is created lazily on first dial and retried if
+// Tor wasn't ready yet. Rotate swaps the onion service for a new one while
+// the old one drains.
+type TorTransport struct {
+	Tor *tor.Tor
+	// SocksAddr, if set, is used instead of asking tor for its SOCKS port
+	SocksAddr string
+	// Auth holds our client auth credential and, if private, who may reach us
+	Auth *ClientAuth
+
+	mu       sync.Mutex
+	onion    *tor.OnionService
+	retiring []*tor.OnionService
+	listener *multiListener
+
+	dialerMu sync.Mutex
+	dialer   *tor.Dialer
+}
+
+func NewTorTransport(t *tor.Tor, onion *tor.OnionService) *TorTransport {
+	tt := &TorTransport{Tor: t, onion: onion, listener: newMultiListener()}
+	tt.listener.add(onion)
+	return tt
+}
+
+func (tt *TorTransport) Address() string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return fmt.Sprintf("%v.onion", tt.onion.ID)
+}
+
+func (tt *TorTransport) Listener() net.Listener { return tt.listener }
+
+func (tt *TorTransport) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	tt.dialerMu.Lock()
+	if tt.dialer == nil {
+		dialCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		d, err := tt.Tor.Dialer(dialCtx, &tor.DialConf{ProxyAddress: tt.SocksAddr, SkipEnableNetwork: attached(tt.Tor)})
+		cancel()
+		if err != nil {
+			tt.dialerMu.Unlock()
+			return nil, err
+		}
+		tt.dialer = d
 	}
+	dialer := tt.dialer
+	tt.dialerMu.Unlock()
 
-	// 3. Start Tor
-	conf := &tor.StartConf{
-		DataDir:     dataDir,
-		DebugWriter: os.Stdout,
+	if tt.Auth != nil {
+		if err := tt.Auth.register(tt.Tor, addr); err != nil {
+			return nil, err
+		}
 	}
+	return dialer.DialContext(ctx, network, addr)
+}
 
-	// Using nil for config to download Tor executable automatically if not present
-	t, err := tor.Start(nil, conf)
+// Rotate implements Rotator with a fresh ephemeral key
+func (tt *TorTransport) Rotate(grace time.Duration) (string, error) {
+	_, key, err := ed25519.GenerateKey(nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("tor start failed: %w", err)
+		return "", err
 	}
-
-	// 4. Create Onion Service
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
 	defer cancel()
+	onion, err := listenOnion(ctx, tt.Tor, key, tt.Auth)
+	if err != nil {
+		return "", fmt.Errorf("onion service failed: %w", err)
+	}
 
-	fmt.Println("🧅 Creating/Restoring V3 Onion Service...")
+	tt.mu.Lock()
+	old := tt.onion
+	tt.onion = onion
+	tt.retiring = append(tt.retiring, old)
+	tt.mu.Unlock()
+	tt.listener.add(onion)
 
-	onion, err := t.Listen(ctx, &tor.ListenConf{
-		Version3:    true,
-		RemotePorts: []int{80},
-		Key:         privKey,
+	time.AfterFunc(grace, func() {
+		tt.mu.Lock()
+		for i, o := range tt.retiring {
+			if o == old {
+				tt.retiring = append(tt.retiring[:i], tt.retiring[i+1:]...)
+				break
+			}
+		}
+		tt.mu.Unlock()
+		old.Close()
+		slog.Info("🗑️  Retired old identity", "addr", old.ID+".onion")
 	})
 
-	return t, onion, err
-}
\ No newline at end of file
+	return fmt.Sprintf("%v.onion", onion.ID), nil
+}
+
+// Sign implements Signer with the current onion's key
+func (tt *TorTransport) Sign(msg []byte) []byte {
+	tt.mu.Lock()
+	kp, _ := tt.onion.Key.(bineed25519.KeyPair)
+	tt.mu.Unlock()
+	if kp == nil {
+		return nil
+	}
+	return bineed25519.Sign(kp, msg)
+}
+
+// Bootstrap implements BootstrapReporter
+func (tt *TorTransport) Bootstrap() BootstrapStatus { return CurrentBootstrap() }
+
+func (tt *TorTransport) Close() error {
+	tt.listener.Close()
+	tt.mu.Lock()
+	tt.onion.Close()
+	for _, o := range tt.retiring {
+		o.Close()
+	}
+	tt.retiring = nil
+	tt.mu.Unlock()
+	return tt.Tor.Close()
+}
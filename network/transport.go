@@ -0,0 +1,98 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cretz/bine/torutil"
+	bineed25519 "github.com/cretz/bine/torutil/ed25519"
+)
+
+// Transport is how a node attaches to the mesh. It owns the node's identity
+// (its onion address), accepts inbound connections for the onion-facing API
+// and dials other nodes by onion address.
+type Transport interface {
+	// Address is this node's "<id>.onion" address
+	Address() string
+	// Listener accepts inbound connections from other nodes
+	Listener() net.Listener
+	// DialContext connects to "<id>.onion:80" style addresses
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	// Close tears down the listener and anything the transport started
+	Close() error
+}
+
+// Signer is implemented by transports that hold the node's identity key.
+// A signature checks out against the onion address itself (see
+// VerifyOnionSignature), which lets peers prove who sent a request.
+type Signer interface {
+	Sign(msg []byte) []byte
+}
+
+// VerifyOnionSignature checks sig over msg against the ed25519 key encoded
+// in a v3 "<id>.onion" address
+func VerifyOnionSignature(addr string, msg, sig []byte) bool {
+	id := strings.TrimSuffix(strings.ToLower(addr), ".onion")
+	if len(id) != 56 {
+		return false
+	}
+	pub, err := torutil.PublicKeyFromV3OnionServiceID(id)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), msg, sig)
+}
+
+// ValidOnion reports whether addr is a well-formed v3 "<id>.onion" address
+func ValidOnion(addr string) bool {
+	id := strings.TrimSuffix(strings.ToLower(addr), ".onion")
+	if len(id) != 56 || id == strings.ToLower(addr) {
+		return false
+	}
+	_, err := torutil.PublicKeyFromV3OnionServiceID(id)
+	return err == nil
+}
+
+// OnionAddress returns the v3 "<id>.onion" address for an identity key
+func OnionAddress(key ed25519.PrivateKey) string {
+	pub := key.Public().(ed25519.PublicKey)
+	return torutil.OnionServiceIDFromV3PublicKey(bineed25519.PublicKey(pub)) + ".onion"
+}
+
+// OpenTransport sets up the transport named by kind ("tor" or "loopback").
+// keyName selects the persistent identity as in SetupTor; torOpts is only
+// used by the tor transport.
+func OpenTransport(kind, keyName string, torOpts TorOptions) (Transport, error) {
+	switch strings.ToLower(kind) {
+	case "", "tor":
+		authPath := torOpts.ClientAuthFile
+		if authPath == "" {
+			authPath = DefaultClientAuthPath()
+		}
+		auth, err := LoadClientAuth(authPath)
+		if err != nil {
+			return nil, fmt.Errorf("client auth: %w", err)
+		}
+		torOpts.ClientAuth = auth
+
+		t, onion, err := SetupTor(keyName, torOpts)
+		if err != nil {
+			return nil, err
+		}
+		tt := NewTorTransport(t, onion)
+		tt.SocksAddr = torOpts.SocksAddr
+		tt.Auth = auth
+		return tt, nil
+	case "loopback":
+		key, err := identityKey(keyName)
+		if err != nil {
+			return nil, err
+		}
+		return DefaultLoopback.Listen(key)
+	default:
+		return nil, fmt.Errorf("unknown transport %q (want tor or loopback)", kind)
+	}
+}
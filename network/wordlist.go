@@ -0,0 +1,38 @@
+package network
+
+// mnemonicWords maps each byte value to a word for key backups. The list is
+// fixed: reordering or editing it would break every written-down backup.
+var mnemonicWords = [256]string{
+	"baby", "back", "band", "bank", "barn", "base", "bath", "bead", "beam",
+	"bean", "bear", "beef", "bell", "belt", "bench", "bird", "blue", "boat",
+	"bolt", "bone", "book", "boot", "bowl", "brave", "bread", "brick",
+	"bridge", "brown", "bulb", "cabin", "cable", "cake", "calm", "camel",
+	"camp", "candy", "canoe", "cape", "card", "cargo", "carpet", "cart",
+	"castle", "cave", "cedar", "chair", "chalk", "charm", "cheek", "chess",
+	"chief", "child", "chin", "circle", "city", "clay", "cliff", "clock",
+	"cloud", "coal", "coast", "coat", "cobra", "comet", "coral", "corn",
+	"couch", "crab", "crane", "crow", "crown", "cube", "dance", "dawn",
+	"deer", "desk", "dial", "diamond", "dock", "dolphin", "door", "dove",
+	"dragon", "dream", "drum", "duck", "dune", "eagle", "earth", "echo",
+	"egg", "elbow", "ember", "engine", "falcon", "farm", "feather", "fence",
+	"fern", "field", "finch", "fire", "fish", "flag", "flame", "flute",
+	"foam", "forest", "fox", "frog", "frost", "fruit", "game", "garden",
+	"gate", "gecko", "ghost", "giant", "glass", "globe", "glove", "goat",
+	"gold", "grape", "grass", "hammer", "harbor", "harp", "hawk", "hazel",
+	"heart", "hill", "hive", "honey", "horse", "house", "iris", "iron",
+	"island", "ivory", "jacket", "jade", "jelly", "jungle", "kayak", "kettle",
+	"king", "kite", "knee", "knot", "lake", "lamp", "lantern", "leaf",
+	"lemon", "lens", "lily", "lime", "lion", "lizard", "lock", "lotus",
+	"lunar", "magnet", "mango", "maple", "marble", "market", "meadow",
+	"melon", "metal", "mint", "mirror", "moon", "moss", "moth", "mountain",
+	"mouse", "nest", "night", "north", "oak", "ocean", "olive", "onion",
+	"orbit", "otter", "owl", "oyster", "paddle", "palm", "panda", "paper",
+	"parrot", "peach", "pearl", "pepper", "piano", "pigeon", "pilot", "pine",
+	"planet", "plum", "pond", "poppy", "quartz", "quill", "rabbit", "radio",
+	"rain", "raven", "reef", "ribbon", "river", "robin", "rocket", "rose",
+	"ruby", "saddle", "salmon", "sand", "scarf", "seal", "shark", "shell",
+	"silver", "snail", "snow", "spider", "spoon", "star", "stone", "storm",
+	"sun", "swan", "table", "tiger", "timber", "toast", "tomato", "tower",
+	"train", "tulip", "turtle", "valley", "velvet", "violin", "walnut",
+	"whale", "wheat", "willow", "window", "winter", "wolf", "yacht", "zebra",
+}
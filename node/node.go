@@ -0,0 +1,265 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+	"onivex/dht"
+	"onivex/discovery"
+	"onivex/filesystem"
+	"onivex/network"
+	"onivex/transfer"
+)
+
+// Node is a full Onivex peer: a PeerManager, a DHT and the onion-facing API
+// served over a Transport. The daemon runs one; the simulation runs many.
+type Node struct {
+	Addr      string
+	Transport network.Transport
+	Peers     *discovery.PeerManager
+	Share     *filesystem.Share
+	Uploads   *transfer.Uploads
+
+	mu     sync.RWMutex
+	server *http.Server
+}
+
+// New wires a node onto an already opened transport
+func New(t network.Transport, dataDir string, share *filesystem.Share) *Node {
+	addr := t.Address()
+	peers := discovery.NewPeerManagerIn(t, dataDir, share)
+	peers.DHT = peers.NewDHT(addr)
+	peers.AddPeer(addr)
+
+	n := &Node{
+		Addr:      addr,
+		Transport: t,
+		Peers:     peers,
+		Share:     share,
+	}
+	n.Uploads = transfer.NewUploads(n.uploadPeer)
+	return n
+}
+
+// uploadPeer names the peer behind a file request for upload fair sharing
+// and quotas, or "" when the request is unsigned
+func (n *Node) uploadPeer(r *http.Request) string {
+	origin, err := discovery.VerifyRequest(r, n.Address())
+	if err != nil {
+		return ""
+	}
+	return origin
+}
+
+// Address returns the node's current onion, which changes on Rotate
+func (n *Node) Address() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.Addr
+}
+
+// Wrapper to log file access requests
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && r.URL.Path[0:4] != "/api" {
+			slog.Info("📂 Serving file", "path", r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler builds the onion-facing API, wrapped in the rate limit,
+// proof-of-work, version and friends-only middleware
+func (n *Node) Handler() http.Handler {
+	peers := n.Peers
+
+	mux := http.NewServeMux()
+
+	fileHandler := n.Share.GetFileHandler()
+	mux.Handle("/", loggingMiddleware(n.Uploads.Handler(fileHandler)))
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OniVex Online"))
+	})
+
+	mux.HandleFunc("/api/hello", func(w http.ResponseWriter, r *http.Request) {
+		peers.HelloHandler(n.Address())(w, r)
+	})
+
+	mux.HandleFunc("/api/peers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var payload map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+				if addr := payload["addr"]; addr != "" && addr != n.Address() {
+					peers.AddPeer(addr)
+					peers.NoteAdvertisedDigest(addr, payload["filter_digest"])
+				}
+			}
+		}
+		digest := peers.LocalFilter().Digest()
+		w.Header().Set(discovery.DigestHeader, digest)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("digests") == "1" {
+			json.NewEncoder(w).Encode(discovery.GossipResponse{FilterDigest: digest, Peers: peers.GossipPeers(config.Current().Network.GossipPeers)})
+			return
+		}
+		json.NewEncoder(w).Encode(peers.GossipPeers(config.Current().Network.GossipPeers))
+	})
+
+	mux.HandleFunc("/api/filter", func(w http.ResponseWriter, r *http.Request) {
+		filter := peers.LocalFilter()
+		w.Header().Set(discovery.DigestHeader, filter.Digest())
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("format") == "packed" {
+			json.NewEncoder(w).Encode(filter.Pack())
+			return
+		}
+		json.NewEncoder(w).Encode(filter)
+	})
+
+	mux.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "Missing query", http.StatusBadRequest)
+			return
+		}
+		discovery.ForwardedQueries.Inc("received")
+		results := n.Share.SearchLocal(query)
+		if len(results) > 0 {
+			slog.Info("💡 Found match for forwarded query", "query", query, "files", len(results))
+		}
+	})
+
+	mux.HandleFunc("/api/index", func(w http.ResponseWriter, r *http.Request) {
+		files, _ := n.Share.GetFileList()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+	})
+
+	// An empty query would match the whole share; /api/index is the
+	// explicit way to list it
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "Missing query", http.StatusBadRequest)
+			return
+		}
+		results := n.Share.SearchLocal(query)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+
+	mux.HandleFunc("/api/hash", func(w http.ResponseWriter, r *http.Request) {
+		meta, ok := n.Share.FindByHash(r.URL.Query().Get("h"))
+		if !ok {
+			http.Error(w, "Not found", 404)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	})
+
+	peers.DHT.Register(mux)
+
+	limiter := discovery.NewLimiter(n.Address)
+	limiter.Trusted = peers.Trusted
+	pow := discovery.NewPoWGuard()
+	return limiter.Middleware(pow.Middleware(discovery.VersionMiddleware(peers.FriendsMiddleware(n.Address, mux))))
+}
+
+// Serve blocks serving h on the transport's listener until Close
+func (n *Node) Serve(h http.Handler) error {
+	n.server = &http.Server{Handler: h}
+	return n.server.Serve(n.Transport.Listener())
+}
+
+// StartBackground runs the periodic gossip, seed manifest and DHT
+// republish loops. The delay gives Tor circuits time to settle before the
+// first bootstrap.
+func (n *Node) StartBackground(delay time.Duration) {
+	peers := n.Peers
+
+	go func() {
+		slog.Info("⏳ Waiting for Tor circuit stability", "delay", delay)
+		time.Sleep(delay)
+		peers.StartSeedRefresh()
+		for {
+			peers.Bootstrap(n.Address())
+			time.Sleep(config.Current().Network.BootstrapInterval.D())
+		}
+	}()
+
+	go func() {
+		// Give gossip a head start so the routing table isn't empty
+		time.Sleep(delay + 2*time.Minute)
+		for {
+			n.RefreshDHT()
+			time.Sleep(dht.RepublishInterval)
+		}
+	}()
+}
+
+// RefreshDHT seeds the routing table from known peers, refreshes our
+// neighbourhood and republishes provider records for our share.
+func (n *Node) RefreshDHT() {
+	for _, p := range n.Peers.GetPeers() {
+		n.Peers.DHT.AddContact(p)
+	}
+	n.Peers.DHT.Refresh()
+	n.Peers.ProvideShares()
+}
+
+// Rotate moves the node to a fresh onion address if its transport supports
+// it. The new address is announced to seeds and peers straight away; the old
+// one keeps serving for grace so in-flight transfers can finish.
+func (n *Node) Rotate(grace time.Duration) error {
+	r, ok := n.Transport.(network.Rotator)
+	if !ok {
+		return fmt.Errorf("transport does not support identity rotation")
+	}
+	old := n.Address()
+	addr, err := r.Rotate(grace)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.Addr = addr
+	n.mu.Unlock()
+
+	n.Peers.RemovePeer(old)
+	n.Peers.AddPeer(addr)
+	n.Peers.DHT.Rekey(addr)
+	slog.Info("🔄 Identity rotated", "old", old, "addr", addr)
+
+	go func() {
+		n.Peers.Bootstrap(addr)
+		n.Peers.ProvideShares()
+	}()
+	return nil
+}
+
+// StartRotation rotates the node's identity every interval
+func (n *Node) StartRotation(interval, grace time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := n.Rotate(grace); err != nil {
+				slog.Warn("⚠️  Identity rotation failed", "err", err)
+			}
+		}
+	}()
+}
+
+// Close stops serving and releases the transport
+func (n *Node) Close() error {
+	if n.server != nil {
+		n.server.Close()
+	}
+	return n.Transport.Close()
+}
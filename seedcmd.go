@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"onivex/config"
+	"onivex/discovery"
+	"onivex/network"
+)
+
+const seedsUsage = `usage: onivex seeds <command> [flags]
+
+  list                      show the seeds this node uses and where they come from
+  keygen [-name n]          create a manifest signing key (data/<name>.key)
+  sign   [-name n] [-in f] [-serial n] [-valid d] [-out f] [onion...]
+                            sign a new manifest, or add a signature to -in
+  verify [file]             check a manifest against the trusted keys
+
+Signing keys use the same -key-passphrase-file / -encrypt-key flags as
+identity keys.`
+
+// runSeedsCommand handles `onivex seeds <subcommand>`
+func runSeedsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, seedsUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = seedsList(args[1:])
+	case "keygen":
+		err = seedsKeygen(args[1:])
+	case "sign":
+		err = seedsSign(args[1:])
+	case "verify":
+		err = seedsVerify(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown seeds command %q\n\n%s\n", args[0], seedsUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// configFlag registers -config, so trusted keys and configured seeds match
+// what the node itself would use
+func configFlag(fs *flag.FlagSet) *string {
+	cwd, _ := os.Getwd()
+	return fs.String("config", filepath.Join(cwd, "data", "config.json"), "Settings file")
+}
+
+func seedsList(args []string) error {
+	fs := flag.NewFlagSet("seeds list", flag.ExitOnError)
+	configPath := configFlag(fs)
+	fs.Parse(args)
+	if _, err := config.Load(*configPath); err != nil {
+		return err
+	}
+
+	cwd, _ := os.Getwd()
+	seeds, source := discovery.LoadSeeds(filepath.Join(cwd, "data")).Source()
+	fmt.Printf("Seeds from %s:\n", source)
+	for _, s := range seeds {
+		fmt.Printf("  %s\n", s)
+	}
+	return nil
+}
+
+func seedsKeygen(args []string) error {
+	fs := flag.NewFlagSet("seeds keygen", flag.ExitOnError)
+	name := fs.String("name", "seed_manifest", "Key to create (data/<name>.key)")
+	network.RegisterKeyFlags(fs)
+	fs.Parse(args)
+
+	if _, err := os.Stat(network.KeyPath(*name)); err == nil {
+		return fmt.Errorf("%s already exists", network.KeyPath(*name))
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	var pass string
+	if network.Keys.WantsEncryption() {
+		if pass, err = network.Keys.Passphrase(*name, true); err != nil {
+			return err
+		}
+	}
+	if err := network.SaveKey(*name, priv, pass); err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)))
+	fmt.Fprintf(os.Stderr, "🔑 Created %s. Nodes only accept manifests it signs once the public key above is in their seed_manifest_keys.\n", network.KeyPath(*name))
+	return nil
+}
+
+func seedsSign(args []string) error {
+	fs := flag.NewFlagSet("seeds sign", flag.ExitOnError)
+	name := fs.String("name", "seed_manifest", "Signing key (data/<name>.key)")
+	in := fs.String("in", "", "Existing manifest to co-sign instead of creating one")
+	serial := fs.Uint64("serial", uint64(time.Now().Unix()), "Manifest serial; must beat the one clients already have")
+	valid := fs.Duration("valid", 90*24*time.Hour, "How long the new manifest is valid")
+	out := fs.String("out", "-", "Output file, or - for stdout")
+	network.RegisterKeyFlags(fs)
+	fs.Parse(args)
+
+	var m *discovery.SeedManifest
+	if *in != "" {
+		if fs.NArg() > 0 {
+			return errors.New("give either -in or seed addresses, not both")
+		}
+		data, err := readInput(*in)
+		if err != nil {
+			return err
+		}
+		if m, err = discovery.ParseSeedManifest(data); err != nil {
+			return err
+		}
+	} else {
+		now := time.Now().UTC().Truncate(time.Second)
+		m = &discovery.SeedManifest{Format: discovery.SeedManifestFormat, Serial: *serial,
+			Issued: now, Expires: now.Add(*valid), Seeds: fs.Args()}
+	}
+	if err := m.Check(); err != nil {
+		return err
+	}
+
+	priv, err := network.LoadKey(*name)
+	if err != nil {
+		return err
+	}
+	m.Sign(priv)
+
+	data, _ := json.MarshalIndent(m, "", "  ")
+	data = append(data, '\n')
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✍️  Signed manifest #%d (%d seeds, %d signature(s)) to %s\n", m.Serial, len(m.Seeds), len(m.Signatures), *out)
+	return nil
+}
+
+func seedsVerify(args []string) error {
+	fs := flag.NewFlagSet("seeds verify", flag.ExitOnError)
+	configPath := configFlag(fs)
+	fs.Parse(args)
+	if _, err := config.Load(*configPath); err != nil {
+		return err
+	}
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	m, err := discovery.ParseSeedManifest(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Manifest #%d, issued %s, expires %s\n", m.Serial, m.Issued.Format(time.RFC3339), m.Expires.Format(time.RFC3339))
+	for _, s := range m.Seeds {
+		fmt.Printf("  seed   %s\n", s)
+	}
+	trusted := map[string]bool{}
+	for _, k := range discovery.TrustedManifestKeys() {
+		trusted[k] = true
+	}
+	for _, k := range m.Signers() {
+		mark := "untrusted"
+		if trusted[k] {
+			mark = "trusted"
+		}
+		fmt.Printf("  signer %s (%s)\n", k, mark)
+	}
+	if err := m.Verify(discovery.TrustedManifestKeys()); err != nil {
+		return err
+	}
+	fmt.Println("✔️  Manifest is valid")
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"onivex/config"
+	"onivex/logging"
+	"onivex/simulation"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 10, "Number of nodes to start")
+	minRecall := flag.Float64("min-recall", 0, "Exit non-zero if mean search recall falls below this")
+	verbose := flag.Bool("v", false, "Show node logs")
+	flag.Parse()
+
+	root, err := os.MkdirTemp("", "onivex-sim-")
+	if err != nil {
+		log.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// Node logs go to stderr so they never mix into the report, and only
+	// errors show unless asked
+	config.Active().Override(func(s *config.Settings) {
+		s.Logging.Sinks = []string{"stderr"}
+		if !*verbose {
+			s.Logging.Level = "error"
+		}
+	})
+	if err := logging.Setup(); err != nil {
+		log.Fatalf("logging: %v", err)
+	}
+	out := os.Stdout
+
+	cluster, err := simulation.NewCluster(root)
+	if err != nil {
+		log.Fatalf("cluster: %v", err)
+	}
+	report, err := cluster.Run(simulation.DefaultScenario(*nodes))
+	cluster.Close()
+
+	report.Print(out)
+	if err != nil {
+		log.Fatalf("scenario failed: %v", err)
+	}
+	if report.MeanRecall() < *minRecall {
+		fmt.Fprintf(out, "❌ mean recall %.3f below %.3f\n", report.MeanRecall(), *minRecall)
+		os.Exit(1)
+	}
+}
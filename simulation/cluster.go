@@ -0,0 +1,289 @@
+package simulation
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/discovery"
+	"onivex/filesystem"
+	"onivex/network"
+	"onivex/node"
+)
+
+// SimNode is one full node inside a Cluster
+type SimNode struct {
+	*node.Node
+	Index int
+	Alive bool
+}
+
+// Cluster runs many full nodes in one process over an in-memory loopback
+// network, with one dedicated seed that shares nothing (like a real seed).
+type Cluster struct {
+	Root    string
+	Network *network.LoopbackNetwork
+	Seed    *SimNode
+	Nodes   []*SimNode
+	Metrics *Metrics
+
+	mu        sync.Mutex
+	partition map[string]int
+}
+
+// NewCluster creates an empty cluster whose node dirs live under root
+func NewCluster(root string) (*Cluster, error) {
+	c := &Cluster{
+		Root:    root,
+		Network: network.NewLoopbackNetwork(""),
+		Metrics: NewMetrics(),
+	}
+	seed, err := c.start("seed")
+	if err != nil {
+		return nil, err
+	}
+	c.Seed = seed
+	return c, nil
+}
+
+func (c *Cluster) start(name string) (*SimNode, error) {
+	t, err := c.Network.Listen(nil)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(c.Root, name)
+	share := filesystem.NewShare(dir)
+	share.EnsureDirectories()
+
+	n := node.New(t, filepath.Join(dir, "data"), share)
+	if c.Seed != nil {
+		n.Peers.Seeds = discovery.FixedSeeds(c.Seed.Addr)
+	} else {
+		n.Peers.Seeds = discovery.FixedSeeds()
+	}
+	go n.Serve(c.Metrics.Count(n.Handler()))
+	return &SimNode{Node: n, Alive: true}, nil
+}
+
+// Join starts count new nodes that know only the seed
+func (c *Cluster) Join(count int) ([]*SimNode, error) {
+	joined := []*SimNode{}
+	for i := 0; i < count; i++ {
+		idx := len(c.Nodes)
+		sn, err := c.start(fmt.Sprintf("node-%03d", idx))
+		if err != nil {
+			return joined, err
+		}
+		sn.Index = idx
+		c.Nodes = append(c.Nodes, sn)
+		joined = append(joined, sn)
+	}
+	return joined, nil
+}
+
+// Leave shuts a node down abruptly; peers only notice when dials fail
+func (c *Cluster) Leave(idx int) {
+	sn := c.Nodes[idx]
+	if !sn.Alive {
+		return
+	}
+	sn.Alive = false
+	sn.Close()
+}
+
+// Live returns the nodes that haven't left
+func (c *Cluster) Live() []*SimNode {
+	live := []*SimNode{}
+	for _, sn := range c.Nodes {
+		if sn.Alive {
+			live = append(live, sn)
+		}
+	}
+	return live
+}
+
+// Partition splits nodes into groups that cannot reach each other. Nodes
+// not listed (and the seed) end up in group 0.
+func (c *Cluster) Partition(groups ...[]int) {
+	assign := map[string]int{}
+	for g, members := range groups {
+		for _, idx := range members {
+			assign[c.Nodes[idx].Addr] = g
+		}
+	}
+	c.mu.Lock()
+	c.partition = assign
+	c.mu.Unlock()
+	c.Network.SetReachable(c.Reachable)
+}
+
+// Heal removes any partition
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	c.partition = nil
+	c.mu.Unlock()
+	c.Network.SetReachable(nil)
+}
+
+// Reachable reports whether two addresses are on the same side of the
+// current partition
+func (c *Cluster) Reachable(from, to string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.partition == nil {
+		return true
+	}
+	return c.partition[from] == c.partition[to]
+}
+
+// AddFile drops a fixture file into a node's uploads folder
+func (c *Cluster) AddFile(idx int, name string, content []byte) error {
+	path := filepath.Join(c.Nodes[idx].Share.UploadsDir, name)
+	return os.WriteFile(path, content, 0644)
+}
+
+// GossipRound has every live node run one Bootstrap concurrently
+func (c *Cluster) GossipRound() {
+	var wg sync.WaitGroup
+	for _, sn := range c.Live() {
+		wg.Add(1)
+		go func(sn *SimNode) {
+			defer wg.Done()
+			sn.Peers.Bootstrap(sn.Addr)
+		}(sn)
+	}
+	wg.Wait()
+}
+
+// Converged reports whether every live node knows every live node it can
+// currently reach
+func (c *Cluster) Converged() bool {
+	live := c.Live()
+	for _, a := range live {
+		known := map[string]bool{}
+		for _, p := range a.Peers.GetPeers() {
+			known[p] = true
+		}
+		for _, b := range live {
+			if a != b && c.Reachable(a.Addr, b.Addr) && !known[b.Addr] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Converge runs gossip rounds until Converged or maxRounds is hit and
+// records the outcome
+func (c *Cluster) Converge(maxRounds int) ConvergenceResult {
+	start := time.Now()
+	before := c.Metrics.Total()
+	res := ConvergenceResult{}
+	for res.Rounds < maxRounds && !c.Converged() {
+		c.GossipRound()
+		res.Rounds++
+	}
+	res.Converged = c.Converged()
+	res.Duration = time.Since(start)
+	res.Messages = c.Metrics.Total() - before
+	return res
+}
+
+// RefreshDHT has every live node republish its share to the DHT
+func (c *Cluster) RefreshDHT() {
+	var wg sync.WaitGroup
+	for _, sn := range c.Live() {
+		wg.Add(1)
+		go func(sn *SimNode) {
+			defer wg.Done()
+			sn.RefreshDHT()
+		}(sn)
+	}
+	wg.Wait()
+}
+
+// Search runs a network search from one node and scores it against the
+// fixtures of every reachable live node
+func (c *Cluster) Search(from int, query string) SearchResult {
+	src := c.Nodes[from]
+	expected := map[string]bool{}
+	for _, sn := range c.Live() {
+		if sn != src && c.Reachable(src.Addr, sn.Addr) && len(sn.Share.SearchLocal(query)) > 0 {
+			expected[sn.Addr] = true
+		}
+	}
+
+	before := c.Metrics.Total()
+	start := time.Now()
+	results := src.Peers.SearchNetwork(query, src.Addr)
+	res := SearchResult{
+		Query:    query,
+		From:     from,
+		Expected: len(expected),
+		Latency:  time.Since(start),
+		Messages: c.Metrics.Total() - before,
+	}
+	for _, r := range results {
+		if expected[r.PeerID] {
+			res.Found++
+		}
+	}
+	res.Recall = recall(res.Found, res.Expected)
+	return res
+}
+
+// LookupHash resolves a content hash through the DHT from one node
+func (c *Cluster) LookupHash(from int, hash string) SearchResult {
+	src := c.Nodes[from]
+	expected := map[string]bool{}
+	for _, sn := range c.Live() {
+		if _, ok := sn.Share.FindByHash(hash); ok && sn != src && c.Reachable(src.Addr, sn.Addr) {
+			expected[sn.Addr] = true
+		}
+	}
+
+	before := c.Metrics.Total()
+	start := time.Now()
+	results := src.Peers.SearchHash(hash, src.Addr)
+	res := SearchResult{
+		Query:    "hash:" + hash[:12],
+		From:     from,
+		Expected: len(expected),
+		Latency:  time.Since(start),
+		Messages: c.Metrics.Total() - before,
+	}
+	for _, r := range results {
+		if expected[r.PeerID] {
+			res.Found++
+		}
+	}
+	res.Recall = recall(res.Found, res.Expected)
+	return res
+}
+
+// Close shuts every node down
+func (c *Cluster) Close() {
+	for i := range c.Nodes {
+		c.Leave(i)
+	}
+	c.Seed.Close()
+}
+
+func recall(found, expected int) float64 {
+	if expected == 0 {
+		return 0
+	}
+	return float64(found) / float64(expected)
+}
+
+// routeOf buckets requests by API route, lumping file downloads together
+func routeOf(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return r.URL.Path
+	}
+	return "/files"
+}
@@ -0,0 +1,138 @@
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics counts every request any node in the cluster served
+type Metrics struct {
+	mu       sync.Mutex
+	messages map[string]int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{messages: make(map[string]int64)}
+}
+
+// Count wraps a node handler so each request is tallied by route
+func (m *Metrics) Count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.messages[routeOf(r)]++
+		m.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Total is the number of requests served so far
+func (m *Metrics) Total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, n := range m.messages {
+		total += n
+	}
+	return total
+}
+
+// ByRoute returns a copy of the per-route counters
+func (m *Metrics) ByRoute() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]int64, len(m.messages))
+	for k, v := range m.messages {
+		out[k] = v
+	}
+	return out
+}
+
+// ConvergenceResult describes one Converge call
+type ConvergenceResult struct {
+	Rounds    int
+	Converged bool
+	Duration  time.Duration
+	Messages  int64
+}
+
+// SearchResult scores one search against the fixtures
+type SearchResult struct {
+	Query    string
+	From     int
+	Expected int
+	Found    int
+	Recall   float64
+	Latency  time.Duration
+	Messages int64
+}
+
+// Report collects everything a scenario measured
+type Report struct {
+	Log         []string
+	Convergence []ConvergenceResult
+	Searches    []SearchResult
+	Messages    map[string]int64
+}
+
+func (r *Report) logf(format string, args ...interface{}) {
+	r.Log = append(r.Log, fmt.Sprintf(format, args...))
+}
+
+// MeanRecall averages recall over the searches that had something to
+// find; a search with no expected results says nothing about recall
+func (r *Report) MeanRecall() float64 {
+	sum, n := 0.0, 0
+	for _, s := range r.Searches {
+		if s.Expected == 0 {
+			continue
+		}
+		sum += s.Recall
+		n++
+	}
+	if n == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
+
+// Print writes a human-readable summary
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintln(w, "== Scenario ==")
+	for _, line := range r.Log {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	fmt.Fprintln(w, "== Convergence ==")
+	for _, c := range r.Convergence {
+		fmt.Fprintf(w, "  converged=%-5v rounds=%-3d time=%-10s messages=%d\n",
+			c.Converged, c.Rounds, c.Duration.Round(time.Millisecond), c.Messages)
+	}
+
+	fmt.Fprintln(w, "== Searches ==")
+	for _, s := range r.Searches {
+		score := fmt.Sprintf("%.2f", s.Recall)
+		if s.Expected == 0 {
+			score = "n/a"
+		}
+		fmt.Fprintf(w, "  %-24q from=%-3d recall=%-4s (%d/%d) latency=%-10s messages=%d\n",
+			s.Query, s.From, score, s.Found, s.Expected, s.Latency.Round(time.Millisecond), s.Messages)
+	}
+	fmt.Fprintf(w, "  mean recall: %.3f\n", r.MeanRecall())
+
+	fmt.Fprintln(w, "== Messages by route ==")
+	routes := make([]string, 0, len(r.Messages))
+	for k := range r.Messages {
+		routes = append(routes, k)
+	}
+	sort.Strings(routes)
+	var total int64
+	for _, k := range routes {
+		fmt.Fprintf(w, "  %-22s %d\n", k, r.Messages[k])
+		total += r.Messages[k]
+	}
+	fmt.Fprintf(w, "  %-22s %d\n", "total", total)
+}
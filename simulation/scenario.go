@@ -0,0 +1,194 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Step is one scripted action in a scenario
+type Step interface {
+	Apply(c *Cluster, r *Report) error
+}
+
+// Join adds N fresh nodes that know only the seed
+type Join struct{ N int }
+
+// Leave kills the given nodes
+type Leave struct{ Nodes []int }
+
+// Partition splits nodes into mutually unreachable groups
+type Partition struct{ Groups [][]int }
+
+// Heal removes the current partition
+type Heal struct{}
+
+// Gossip runs a fixed number of gossip rounds
+type Gossip struct{ Rounds int }
+
+// Converge gossips until every node knows every reachable node
+type Converge struct{ MaxRounds int }
+
+// File shares a fixture file of Size bytes on Node
+type File struct {
+	Node int
+	Name string
+	Size int
+}
+
+// PublishDHT has every live node announce its share to the DHT
+type PublishDHT struct{}
+
+// Search queries the mesh from one node and scores recall
+type Search struct {
+	From  int
+	Query string
+}
+
+// Lookup resolves the hash of a fixture file on Owner through the DHT
+type Lookup struct {
+	From  int
+	Owner int
+	Name  string
+}
+
+func (s Join) Apply(c *Cluster, r *Report) error {
+	_, err := c.Join(s.N)
+	r.logf("join %d (total %d)", s.N, len(c.Nodes))
+	return err
+}
+
+func (s Leave) Apply(c *Cluster, r *Report) error {
+	for _, idx := range s.Nodes {
+		c.Leave(idx)
+	}
+	r.logf("leave %v (live %d)", s.Nodes, len(c.Live()))
+	return nil
+}
+
+func (s Partition) Apply(c *Cluster, r *Report) error {
+	c.Partition(s.Groups...)
+	r.logf("partition %v", s.Groups)
+	return nil
+}
+
+func (Heal) Apply(c *Cluster, r *Report) error {
+	c.Heal()
+	r.logf("heal")
+	return nil
+}
+
+func (s Gossip) Apply(c *Cluster, r *Report) error {
+	for i := 0; i < s.Rounds; i++ {
+		c.GossipRound()
+	}
+	r.logf("gossip %d rounds", s.Rounds)
+	return nil
+}
+
+func (s Converge) Apply(c *Cluster, r *Report) error {
+	res := c.Converge(s.MaxRounds)
+	r.Convergence = append(r.Convergence, res)
+	r.logf("converge: %v after %d rounds", res.Converged, res.Rounds)
+	return nil
+}
+
+func (s File) Apply(c *Cluster, r *Report) error {
+	return c.AddFile(s.Node, s.Name, fixtureContent(s.Node, s.Name, s.Size))
+}
+
+func (PublishDHT) Apply(c *Cluster, r *Report) error {
+	c.RefreshDHT()
+	r.logf("dht publish")
+	return nil
+}
+
+func (s Search) Apply(c *Cluster, r *Report) error {
+	r.Searches = append(r.Searches, c.Search(s.From, s.Query))
+	return nil
+}
+
+func (s Lookup) Apply(c *Cluster, r *Report) error {
+	meta, ok := findFixture(c, s.Owner, s.Name)
+	if !ok {
+		return fmt.Errorf("fixture %s not found on node %d", s.Name, s.Owner)
+	}
+	r.Searches = append(r.Searches, c.LookupHash(s.From, meta))
+	return nil
+}
+
+// Run applies steps in order and returns the collected report
+func (c *Cluster) Run(steps []Step) (*Report, error) {
+	r := &Report{}
+	for i, step := range steps {
+		if err := step.Apply(c, r); err != nil {
+			return r, fmt.Errorf("step %d (%T): %w", i, step, err)
+		}
+	}
+	r.Messages = c.Metrics.ByRoute()
+	return r, nil
+}
+
+// fixtureContent makes deterministic, node-specific file bodies so equal
+// names on different nodes still hash differently
+func fixtureContent(node int, name string, size int) []byte {
+	seed := fmt.Sprintf("onivex-fixture:%d:%s:", node, name)
+	if size <= 0 {
+		size = len(seed)
+	}
+	return []byte(strings.Repeat(seed, size/len(seed)+1)[:size])
+}
+
+func findFixture(c *Cluster, owner int, name string) (string, bool) {
+	files, _ := c.Nodes[owner].Share.GetFileList()
+	for _, f := range files {
+		if f.Name == name {
+			return f.Hash, true
+		}
+	}
+	return "", false
+}
+
+// DefaultScenario exercises join, gossip convergence, keyword search, DHT
+// lookup, a two-way partition and churn on a mesh of n nodes.
+func DefaultScenario(n int) []Step {
+	if n < 4 {
+		n = 4
+	}
+	topics := []string{"linux", "ubuntu", "music", "book", "video", "paper"}
+
+	steps := []Step{Join{N: n}}
+	for i := 0; i < n; i++ {
+		steps = append(steps, File{Node: i, Name: fmt.Sprintf("%s-%03d.txt", topics[i%len(topics)], i), Size: 4096})
+	}
+	steps = append(steps,
+		Converge{MaxRounds: 10},
+		PublishDHT{},
+		Search{From: 0, Query: "linux"},
+		Search{From: n - 1, Query: "music"},
+		Lookup{From: 0, Owner: n - 1, Name: fmt.Sprintf("%s-%03d.txt", topics[(n-1)%len(topics)], n-1)},
+	)
+
+	half := []int{}
+	rest := []int{}
+	for i := 0; i < n; i++ {
+		if i < n/2 {
+			half = append(half, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	steps = append(steps,
+		Partition{Groups: [][]int{half, rest}},
+		Search{From: 0, Query: "book"},
+		Heal{},
+		Converge{MaxRounds: 10},
+		Search{From: 0, Query: "book"},
+		Leave{Nodes: []int{1, n - 2}},
+		Gossip{Rounds: 1},
+		Search{From: 0, Query: "video"},
+		Join{N: 2},
+		Converge{MaxRounds: 10},
+		Search{From: n, Query: "paper"},
+	)
+	return steps
+}
@@ -0,0 +1,51 @@
+package simulation
+
+import "testing"
+
+func TestClusterConvergesAndFindsFiles(t *testing.T) {
+	c, err := NewCluster(t.TempDir())
+	if err != nil {
+		t.Fatalf("cluster: %v", err)
+	}
+	defer c.Close()
+
+	report, err := c.Run([]Step{
+		Join{N: 4},
+		File{Node: 0, Name: "linux-000.txt", Size: 1024},
+		File{Node: 3, Name: "linux-003.txt", Size: 1024},
+		File{Node: 2, Name: "music-002.txt", Size: 1024},
+		Converge{MaxRounds: 10},
+		PublishDHT{},
+		Search{From: 1, Query: "linux"},
+		Search{From: 0, Query: "music"},
+		Lookup{From: 0, Owner: 3, Name: "linux-003.txt"},
+	})
+	if err != nil {
+		t.Fatalf("scenario: %v", err)
+	}
+
+	if len(report.Convergence) != 1 || !report.Convergence[0].Converged {
+		t.Fatalf("cluster did not converge: %+v", report.Convergence)
+	}
+	for _, s := range report.Searches {
+		if s.Expected == 0 {
+			t.Errorf("search %q from %d expected nothing; fixtures not indexed", s.Query, s.From)
+		}
+		if s.Recall != 1 {
+			t.Errorf("search %q from %d: recall %.2f (%d/%d)", s.Query, s.From, s.Recall, s.Found, s.Expected)
+		}
+	}
+	if got := report.MeanRecall(); got != 1 {
+		t.Errorf("mean recall %.3f, want 1", got)
+	}
+}
+
+func TestMeanRecallSkipsSearchesWithNothingToFind(t *testing.T) {
+	r := &Report{Searches: []SearchResult{
+		{Query: "a", Expected: 2, Found: 1, Recall: recall(1, 2)},
+		{Query: "none", Expected: 0, Found: 0, Recall: recall(0, 0)},
+	}}
+	if got := r.MeanRecall(); got != 0.5 {
+		t.Errorf("mean recall %.3f, want 0.5", got)
+	}
+}
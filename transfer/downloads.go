@@ -0,0 +1,134 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"onivex/config"
+)
+
+// Limiter shares one rate between any number of concurrent transfers
+type Limiter struct {
+	// Rate is read before every chunk, in bytes per second (0 = unlimited)
+	Rate func() float64
+
+	mu    sync.Mutex
+	pacer Pacer
+	total atomic.Int64
+}
+
+// NewDownloadLimiter returns a limiter following the downloads settings
+func NewDownloadLimiter() *Limiter {
+	return &Limiter{Rate: func() float64 {
+		kib, _ := config.Current().Downloads.RateAt(time.Now())
+		return float64(kib) * 1024
+	}}
+}
+
+// Wait blocks until n more bytes may pass
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	wait := l.pacer.reserve(n, l.Rate())
+	l.mu.Unlock()
+	return sleep(ctx, wait)
+}
+
+// Total is how many bytes have passed the limiter
+func (l *Limiter) Total() int64 { return l.total.Load() }
+
+// ErrStalled is returned by a response body that received nothing for
+// longer than the Transport's Idle
+var ErrStalled = errors.New("transfer stalled")
+
+// Transport paces every response body it returns through Limiter. With
+// Idle set, a body that receives nothing for that long is aborted; time
+// spent waiting on Limiter doesn't count.
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+	Idle    time.Duration
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Idle <= 0 {
+		resp, err := t.Base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		resp.Body = t.pace(req, resp.Body)
+		return resp, nil
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	b := &idleBody{ReadCloser: resp.Body, idle: t.Idle, cancel: cancel}
+	b.timer = time.AfterFunc(t.Idle, func() {
+		b.stalled.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	resp.Body = t.pace(req, b)
+	return resp, nil
+}
+
+func (t *Transport) pace(req *http.Request, body io.ReadCloser) io.ReadCloser {
+	if t.Limiter == nil {
+		return body
+	}
+	return &pacedBody{ReadCloser: body, ctx: req.Context(), l: t.Limiter}
+}
+
+// idleBody cancels its request when a single read waits longer than idle
+type idleBody struct {
+	io.ReadCloser
+	idle    time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.idle)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.stalled.Load() {
+		err = ErrStalled
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+type pacedBody struct {
+	io.ReadCloser
+	ctx context.Context
+	l   *Limiter
+}
+
+func (b *pacedBody) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.l.total.Add(int64(n))
+		if werr := b.l.Wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
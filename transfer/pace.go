@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"context"
+	"time"
+)
+
+// chunkSize is how many bytes pass between rate checks
+const chunkSize = 16 << 10
+
+// Pacer spaces out writes so they average a given rate. The rate is passed
+// on every call, so a changed setting or a new fair share applies to the
+// next chunk.
+type Pacer struct {
+	next time.Time
+}
+
+// Wait blocks until n more bytes may pass at rate bytes per second. A rate
+// of 0 or less means unlimited.
+func (p *Pacer) Wait(ctx context.Context, n int, rate float64) error {
+	return sleep(ctx, p.reserve(n, rate))
+}
+
+// reserve books n bytes at rate and returns how long to wait before
+// sending them
+func (p *Pacer) reserve(n int, rate float64) time.Duration {
+	now := time.Now()
+	if rate <= 0 {
+		p.next = now
+		return 0
+	}
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	return time.Until(p.next)
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,340 @@
+// Package transfer limits the files a node moves: the uploads it serves to
+// other peers and the downloads it makes.
+package transfer
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"onivex/config"
+	"onivex/metrics"
+)
+
+// errQuota aborts an upload that runs past the peer's daily quota
+var errQuota = errors.New("daily upload quota reached")
+
+// AnonymousPeer names the peer of an unsigned request in upload stats
+const AnonymousPeer = "anonymous"
+
+// Upload is one transfer we serve, running or queued
+type Upload struct {
+	ID      int       `json:"id"`
+	Peer    string    `json:"peer"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Bytes   int64     `json:"bytes"`
+	Started time.Time `json:"started"`
+	Queued  bool      `json:"queued"`
+
+	// Anonymous uploads have no verified peer. Each counts as a peer of
+	// its own, so PerPeer and quotas never lump unrelated downloaders
+	// together.
+	Anonymous bool `json:"anonymous,omitempty"`
+
+	// Rate is the average bytes/s so far; Share is the fair share of the
+	// upload rate limit it currently gets (0 = unlimited)
+	Rate  float64 `json:"rate"`
+	Share float64 `json:"share"`
+}
+
+// key is who the upload counts against for fair sharing
+func (up *Upload) key() string {
+	if up.Anonymous {
+		return "#" + strconv.Itoa(up.ID)
+	}
+	return up.Peer
+}
+
+type waiter struct {
+	up    *Upload
+	ready chan struct{}
+}
+
+// Uploads admits and paces uploads according to the uploads settings.
+// Peers are told apart by Identify, e.g. from a signed request origin;
+// "" means the request can't be tied to a peer.
+type Uploads struct {
+	Identify func(*http.Request) string
+
+	mu     sync.Mutex
+	nextID int
+	active map[int]*Upload
+	queue  []*waiter
+	day    string
+	served map[string]int64 // bytes per peer today (UTC)
+	total  int64
+}
+
+// NewUploads returns an upload manager; identify names the requesting peer
+func NewUploads(identify func(*http.Request) string) *Uploads {
+	return &Uploads{Identify: identify, active: make(map[int]*Upload), served: make(map[string]int64)}
+}
+
+// todayLocked resets the quotas when the UTC day changes
+func (u *Uploads) todayLocked() {
+	if day := time.Now().UTC().Format("2006-01-02"); day != u.day {
+		u.day, u.served = day, make(map[string]int64)
+	}
+}
+
+func (u *Uploads) peerActiveLocked(key string) int {
+	n := 0
+	for _, up := range u.active {
+		if up.key() == key {
+			n++
+		}
+	}
+	return n
+}
+
+// shareLocked splits the rate limit evenly between peers, then between
+// each peer's uploads, so one peer opening many transfers gains nothing
+func (u *Uploads) shareLocked(key string, rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	peers := map[string]int{}
+	for _, up := range u.active {
+		peers[up.key()]++
+	}
+	if peers[key] == 0 {
+		return rate
+	}
+	return rate / float64(len(peers)) / float64(peers[key])
+}
+
+// promoteLocked starts queued uploads while slots are free, preferring
+// peers with the fewest running uploads, then the longest waiting
+func (u *Uploads) promoteLocked(lim config.UploadSettings) {
+	for len(u.active) < lim.MaxActive {
+		best := -1
+		for i, w := range u.queue {
+			n := u.peerActiveLocked(w.up.key())
+			if n >= lim.PerPeer {
+				continue
+			}
+			if best == -1 || n < u.peerActiveLocked(u.queue[best].up.key()) {
+				best = i
+			}
+		}
+		if best == -1 {
+			return
+		}
+		w := u.queue[best]
+		u.queue = append(u.queue[:best], u.queue[best+1:]...)
+		w.up.Queued, w.up.Started = false, time.Now()
+		u.active[w.up.ID] = w.up
+		close(w.ready)
+	}
+}
+
+func (u *Uploads) dequeueLocked(w *waiter) {
+	for i, q := range u.queue {
+		if q == w {
+			u.queue = append(u.queue[:i], u.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// admit starts an upload for peer ("" if unknown), queueing it if no slot
+// is free. The returned status is non-zero when the request should be
+// refused.
+func (u *Uploads) admit(r *http.Request, peer string) (*Upload, int, time.Duration) {
+	lim := config.Current().Uploads
+	anonymous := peer == ""
+	if anonymous {
+		peer = AnonymousPeer
+	}
+	u.mu.Lock()
+	u.todayLocked()
+	if quota := int64(lim.DailyQuotaMiB) << 20; quota > 0 && !anonymous && u.served[peer] >= quota {
+		u.mu.Unlock()
+		now := time.Now().UTC()
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return nil, http.StatusTooManyRequests, midnight.Sub(now)
+	}
+
+	u.nextID++
+	up := &Upload{ID: u.nextID, Peer: peer, Path: r.URL.Path, Started: time.Now(), Anonymous: anonymous}
+	if len(u.queue) == 0 && len(u.active) < lim.MaxActive && u.peerActiveLocked(up.key()) < lim.PerPeer {
+		u.active[up.ID] = up
+		u.mu.Unlock()
+		return up, 0, 0
+	}
+	if len(u.queue) >= lim.QueueSize {
+		u.mu.Unlock()
+		return nil, http.StatusServiceUnavailable, 30 * time.Second
+	}
+	up.Queued = true
+	w := &waiter{up: up, ready: make(chan struct{})}
+	u.queue = append(u.queue, w)
+	u.promoteLocked(lim)
+	u.mu.Unlock()
+
+	timer := time.NewTimer(lim.QueueTimeout.D())
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return up, 0, 0
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Promoted just as we gave up; hand the slot back
+		delete(u.active, up.ID)
+		u.promoteLocked(lim)
+	default:
+		u.dequeueLocked(w)
+	}
+	return nil, http.StatusServiceUnavailable, 30 * time.Second
+}
+
+func (u *Uploads) finish(up *Upload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.active, up.ID)
+	u.promoteLocked(config.Current().Uploads)
+}
+
+// Handler wraps a file server with admission, pacing and quotas
+func (u *Uploads) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := u.Identify(r)
+		up, status, wait := u.admit(r, peer)
+		if status != 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			msg := "Upload queue full, try again later"
+			if status == http.StatusTooManyRequests {
+				msg = errQuota.Error()
+			}
+			http.Error(w, msg, status)
+			return
+		}
+		defer u.finish(up)
+		next.ServeHTTP(&pacedWriter{ResponseWriter: w, r: r, u: u, up: up}, r)
+	})
+}
+
+// pacedWriter meters an upload. It deliberately hides io.ReaderFrom so
+// every byte goes through Write.
+type pacedWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	u     *Uploads
+	up    *Upload
+	pacer Pacer
+}
+
+func (pw *pacedWriter) WriteHeader(code int) {
+	if n, err := strconv.ParseInt(pw.Header().Get("Content-Length"), 10, 64); err == nil {
+		pw.u.mu.Lock()
+		pw.up.Size = n
+		pw.u.mu.Unlock()
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *pacedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		lim := config.Current().Uploads
+		pw.u.mu.Lock()
+		pw.u.todayLocked()
+		quota := int64(lim.DailyQuotaMiB) << 20
+		over := quota > 0 && !pw.up.Anonymous && pw.u.served[pw.up.Peer] >= quota
+		share := pw.u.shareLocked(pw.up.key(), float64(lim.RateKiB)*1024)
+		pw.up.Share = share
+		pw.u.mu.Unlock()
+		if over {
+			return written, errQuota
+		}
+		if err := pw.pacer.Wait(pw.r.Context(), len(chunk), share); err != nil {
+			return written, err
+		}
+
+		n, err := pw.ResponseWriter.Write(chunk)
+		written += n
+		pw.u.mu.Lock()
+		pw.up.Bytes += int64(n)
+		pw.u.served[pw.up.Peer] += int64(n)
+		pw.u.total += int64(n)
+		pw.u.mu.Unlock()
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// UploadStats is the Monitor view's picture of our uploads
+type UploadStats struct {
+	Active      []Upload              `json:"active"`
+	Queued      []Upload              `json:"queued"`
+	ServedToday map[string]int64      `json:"served_today"`
+	TotalBytes  int64                 `json:"total_bytes"`
+	Limits      config.UploadSettings `json:"limits"`
+}
+
+// Snapshot copies the current upload state
+func (u *Uploads) Snapshot() UploadStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.todayLocked()
+	stats := UploadStats{
+		Active:      []Upload{},
+		Queued:      []Upload{},
+		ServedToday: make(map[string]int64, len(u.served)),
+		TotalBytes:  u.total,
+		Limits:      config.Current().Uploads,
+	}
+	for _, up := range u.active {
+		cp := *up
+		if secs := time.Since(up.Started).Seconds(); secs > 0 {
+			cp.Rate = float64(up.Bytes) / secs
+		}
+		stats.Active = append(stats.Active, cp)
+	}
+	sort.Slice(stats.Active, func(i, j int) bool { return stats.Active[i].ID < stats.Active[j].ID })
+	for _, w := range u.queue {
+		stats.Queued = append(stats.Queued, *w.up)
+	}
+	for peer, n := range u.served {
+		stats.ServedToday[peer] = n
+	}
+	return stats
+}
+
+// RegisterMetrics adds scrape-time upload gauges to the default registry
+func (u *Uploads) RegisterMetrics() {
+	r := metrics.Default
+	r.CounterFunc("onivex_uploaded_bytes_total", "Bytes served to peers", func() float64 {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return float64(u.total)
+	})
+	r.GaugeFunc("onivex_active_uploads", "Uploads being served", func() float64 {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return float64(len(u.active))
+	})
+	r.GaugeFunc("onivex_queued_uploads", "Uploads waiting for a slot", func() float64 {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return float64(len(u.queue))
+	})
+}
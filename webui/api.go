@@ -0,0 +1,514 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"onivex/config"
+	"onivex/discovery"
+	"onivex/filesystem"
+	"onivex/network"
+	"onivex/transfer"
+)
+
+// The /api/v1 control API. Every route is listed in (*api).routes, which also
+// generates the OpenAPI spec served at /api/v1/openapi.json, so a route
+// can't be added without being documented.
+
+// APIError is the body of every /api/v1 error response
+type APIError struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, APIError{ErrorDetail{Status: status, Code: code, Message: msg}})
+}
+
+// decodeBody reads a JSON request body into v, rejecting unknown fields
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Bad JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// NodeInfo describes this node
+type NodeInfo struct {
+	Address      string   `json:"address"`
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	FriendsOnly  bool     `json:"friends_only"`
+	Peers        int      `json:"peers"`
+	Seeds        []string `json:"seeds"`
+}
+
+// Peer is an entry of the peer table
+type Peer struct {
+	Address      string     `json:"address"`
+	LastSeen     time.Time  `json:"last_seen"`
+	Version      string     `json:"version,omitempty"`
+	Capabilities []string   `json:"capabilities"`
+	HelloAt      *time.Time `json:"hello_at,omitempty"`
+	Incompatible bool       `json:"incompatible"`
+	HasFilter    bool       `json:"has_filter"`
+	Seed         bool       `json:"seed"`
+	Friend       bool       `json:"friend"`
+}
+
+type PeerRequest struct {
+	Address string `json:"address"`
+}
+
+// Identity is who this node is on the mesh
+type Identity struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	// KeyFile and Encrypted describe the key of a persistent identity
+	KeyFile    string `json:"key_file,omitempty"`
+	Encrypted  bool   `json:"encrypted"`
+	FriendCard string `json:"friend_card,omitempty"`
+}
+
+type SearchRequest struct {
+	Query string `json:"query"`
+}
+
+// Search is a finished search: our own matches first, then each peer's
+type Search struct {
+	Query   string                   `json:"query"`
+	Results []discovery.SearchResult `json:"results"`
+	TookMS  int64                    `json:"took_ms"`
+}
+
+type ShareRequest struct {
+	// Path is a file on this machine to copy into the shared folder
+	Path string `json:"path"`
+	Name string `json:"name,omitempty"`
+}
+
+type DownloadRequest struct {
+	Peer string `json:"peer"`
+	Path string `json:"path"`
+	Name string `json:"name,omitempty"`
+}
+
+// SettingsView is the saved settings file next to the settings in effect,
+// which include environment and command-line overrides
+type SettingsView struct {
+	Settings        config.Settings `json:"settings"`
+	Effective       config.Settings `json:"effective"`
+	RestartFields   []string        `json:"restart_fields"`
+	RestartRequired []string        `json:"restart_required,omitempty"`
+	Path            string          `json:"path"`
+}
+
+func settingsView(restart []string) SettingsView {
+	store := config.Active()
+	return SettingsView{
+		Settings:        store.File(),
+		Effective:       store.Get(),
+		RestartFields:   config.RestartFields(),
+		RestartRequired: restart,
+		Path:            store.Path(),
+	}
+}
+
+type apiParam struct {
+	Name, Description string
+	Required          bool
+}
+
+type apiRoute struct {
+	Method, Path string
+	ID, Summary  string
+	Query        []apiParam
+	Body         any // request model, nil for none
+	Response     any // response model, nil for an empty 204
+	Status       int // on success; 200 if zero
+	handle       http.HandlerFunc
+}
+
+type api struct {
+	pm        *discovery.PeerManager
+	t         network.Transport
+	uploads   *transfer.Uploads
+	downloads *downloadManager
+}
+
+func (a *api) routes() []apiRoute {
+	return []apiRoute{
+		{Method: "GET", Path: "/api/v1/node", ID: "getNode", Summary: "This node's address, version and capabilities",
+			Response: NodeInfo{}, handle: a.getNode},
+		{Method: "GET", Path: "/api/v1/identity", ID: "getIdentity", Summary: "Our onion identity and friend card",
+			Query:    []apiParam{{Name: "name", Description: "Name to put on the friend card"}},
+			Response: Identity{}, handle: a.getIdentity},
+		{Method: "GET", Path: "/api/v1/peers", ID: "listPeers", Summary: "The peer table",
+			Response: []Peer{}, handle: a.listPeers},
+		{Method: "POST", Path: "/api/v1/peers", ID: "addPeer", Summary: "Add a peer by onion address",
+			Body: PeerRequest{}, Response: Peer{}, Status: http.StatusCreated, handle: a.addPeer},
+		{Method: "DELETE", Path: "/api/v1/peers/{address}", ID: "removePeer", Summary: "Forget a peer",
+			Status: http.StatusNoContent, handle: a.removePeer},
+		{Method: "POST", Path: "/api/v1/searches", ID: "createSearch", Summary: "Search our share and the mesh by name, or the DHT by content hash",
+			Body: SearchRequest{}, Response: Search{}, handle: a.createSearch},
+		{Method: "GET", Path: "/api/v1/downloads", ID: "listDownloads", Summary: "Downloads started through the API, oldest first",
+			Response: []Download{}, handle: a.listDownloads},
+		{Method: "POST", Path: "/api/v1/downloads", ID: "createDownload", Summary: "Start downloading a file from a peer into the library",
+			Body: DownloadRequest{}, Response: Download{}, Status: http.StatusAccepted, handle: a.createDownload},
+		{Method: "GET", Path: "/api/v1/downloads/{id}", ID: "getDownload", Summary: "One download's progress",
+			Response: Download{}, handle: a.getDownload},
+		{Method: "DELETE", Path: "/api/v1/downloads/{id}", ID: "deleteDownload", Summary: "Cancel a running or paused download, or forget a finished one",
+			Status: http.StatusNoContent, handle: a.deleteDownload},
+		{Method: "POST", Path: "/api/v1/downloads/{id}/pause", ID: "pauseDownload", Summary: "Pause a running download, keeping the part fetched so far",
+			Response: Download{}, handle: a.pauseDownload},
+		{Method: "POST", Path: "/api/v1/downloads/{id}/resume", ID: "resumeDownload", Summary: "Resume a paused or failed download where it stopped",
+			Response: Download{}, Status: http.StatusAccepted, handle: a.resumeDownload},
+		{Method: "GET", Path: "/api/v1/uploads", ID: "listUploads", Summary: "Uploads we are serving, queued uploads and the upload limits",
+			Response: transfer.UploadStats{}, handle: a.listUploads},
+		{Method: "GET", Path: "/api/v1/shares", ID: "listShares", Summary: "Files we share with the mesh",
+			Response: []filesystem.FileMeta{}, handle: a.listShares},
+		{Method: "POST", Path: "/api/v1/shares", ID: "addShare", Summary: "Copy a local file into the shared folder",
+			Body: ShareRequest{}, Response: filesystem.FileMeta{}, Status: http.StatusCreated, handle: a.addShare},
+		{Method: "GET", Path: "/api/v1/library", ID: "listLibrary", Summary: "Files we have downloaded",
+			Response: []filesystem.FileMeta{}, handle: a.listLibrary},
+		{Method: "GET", Path: "/api/v1/settings", ID: "getSettings", Summary: "Saved and effective settings",
+			Response: SettingsView{}, handle: a.getSettings},
+		{Method: "PUT", Path: "/api/v1/settings", ID: "putSettings", Summary: "Save settings; omitted fields keep their saved values",
+			Body: config.Settings{}, Response: SettingsView{}, handle: a.putSettings},
+		{Method: "GET", Path: "/api/v1/openapi.json", ID: "getOpenAPI", Summary: "This API's OpenAPI 3 description",
+			handle: a.getOpenAPI},
+	}
+}
+
+// register mounts the routes on mux, plus a catch-all that answers 404
+// and 405 in the API's error format
+func (a *api) register(mux *http.ServeMux) {
+	routes := a.routes()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.Method+" "+rt.Path, rt.handle)
+	}
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, rt := range routes {
+			if pathMatches(rt.Path, r.URL.Path) {
+				allow = append(allow, rt.Method)
+			}
+		}
+		if len(allow) == 0 {
+			writeError(w, http.StatusNotFound, "not_found", "No such endpoint")
+			return
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use "+strings.Join(allow, " or "))
+	})
+}
+
+func pathMatches(pattern, path string) bool {
+	ps, xs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(ps) != len(xs) {
+		return false
+	}
+	for i := range ps {
+		if ps[i] != xs[i] && !strings.HasPrefix(ps[i], "{") {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *api) getNode(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, NodeInfo{
+		Address:      a.t.Address(),
+		Version:      config.ProtocolVersion,
+		Capabilities: a.pm.Capabilities,
+		FriendsOnly:  a.pm.FriendsOnly,
+		Peers:        len(a.pm.GetPeers()),
+		Seeds:        a.pm.Seeds.List(),
+	})
+}
+
+func (a *api) peer(addr string, info discovery.PeerInfo) Peer {
+	p := Peer{
+		Address:      addr,
+		LastSeen:     info.LastSeen,
+		Version:      info.Version,
+		Capabilities: info.Capabilities,
+		Incompatible: info.Incompatible,
+		HasFilter:    info.Filter != nil,
+		Seed:         a.pm.Seeds.Has(addr),
+		Friend:       a.pm.Friends.Has(addr),
+	}
+	if p.Capabilities == nil {
+		p.Capabilities = []string{}
+	}
+	if !info.HelloAt.IsZero() {
+		hello := info.HelloAt
+		p.HelloAt = &hello
+	}
+	return p
+}
+
+func (a *api) listPeers(w http.ResponseWriter, r *http.Request) {
+	peers := []Peer{}
+	for addr, info := range a.pm.PeerInfos() {
+		peers = append(peers, a.peer(addr, info))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (a *api) addPeer(w http.ResponseWriter, r *http.Request) {
+	var req PeerRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	addr := strings.ToLower(strings.TrimSpace(req.Address))
+	if !network.ValidOnion(addr) {
+		writeError(w, http.StatusBadRequest, "bad_request", "address must be a v3 onion address")
+		return
+	}
+	a.pm.AddPeer(addr)
+	info, ok := a.pm.PeerInfos()[addr]
+	if !ok {
+		writeError(w, http.StatusForbidden, "not_a_friend", "Friends-only mode: add the peer as a friend instead")
+		return
+	}
+	go a.pm.Sync(addr, a.t.Address())
+	writeJSON(w, http.StatusCreated, a.peer(addr, info))
+}
+
+func (a *api) removePeer(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("address")
+	if _, ok := a.pm.PeerInfos()[addr]; !ok {
+		writeError(w, http.StatusNotFound, "not_found", "no such peer")
+		return
+	}
+	a.pm.RemovePeer(addr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) getIdentity(w http.ResponseWriter, r *http.Request) {
+	mode, _ := network.ParseIdentityMode(config.Current().Identity)
+	id := Identity{Address: a.t.Address(), Mode: string(mode)}
+	if name := mode.KeyName(network.ClientKeyName); name != "" {
+		id.KeyFile = network.KeyPath(name)
+		id.Encrypted, _ = network.KeyEncrypted(name)
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Onivex node"
+	}
+	if card, err := discovery.NewFriendCard(a.t, name); err == nil {
+		id.FriendCard = card.Encode()
+	}
+	writeJSON(w, http.StatusOK, id)
+}
+
+func (a *api) createSearch(w http.ResponseWriter, r *http.Request) {
+	var req SearchRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "query is required")
+		return
+	}
+	start := time.Now()
+	results := runSearch(a.pm, a.t, query)
+	if results == nil {
+		results = []discovery.SearchResult{}
+	}
+	writeJSON(w, http.StatusOK, Search{Query: query, Results: results, TookMS: time.Since(start).Milliseconds()})
+}
+
+func (a *api) listDownloads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.downloads.List())
+}
+
+// downloadError reports a downloadManager error
+func downloadError(w http.ResponseWriter, err error) {
+	var fe *fetchError
+	switch {
+	case errors.As(err, &fe):
+		writeError(w, fe.Status, fe.Code, fe.Msg)
+	case errors.Is(err, errNoDownload):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	default:
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	}
+}
+
+func (a *api) createDownload(w http.ResponseWriter, r *http.Request) {
+	var req DownloadRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	d, err := a.downloads.Start(req.Peer, req.Path, req.Name)
+	if err != nil {
+		downloadError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/downloads/"+d.ID)
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+func (a *api) getDownload(w http.ResponseWriter, r *http.Request) {
+	d, ok := a.downloads.Get(r.PathValue("id"))
+	if !ok {
+		downloadError(w, errNoDownload)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (a *api) deleteDownload(w http.ResponseWriter, r *http.Request) {
+	if err := a.downloads.Remove(r.PathValue("id")); err != nil {
+		downloadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) pauseDownload(w http.ResponseWriter, r *http.Request) {
+	d, err := a.downloads.Pause(r.PathValue("id"))
+	if err != nil {
+		downloadError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (a *api) resumeDownload(w http.ResponseWriter, r *http.Request) {
+	d, err := a.downloads.Resume(r.PathValue("id"))
+	if err != nil {
+		downloadError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+func (a *api) listUploads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.uploads.Snapshot())
+}
+
+func (a *api) listShares(w http.ResponseWriter, r *http.Request) {
+	files, err := a.pm.Share.GetFileList()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "Failed to scan shared files")
+		return
+	}
+	if files == nil {
+		files = []filesystem.FileMeta{}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (a *api) addShare(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	name := req.Name
+	if name == "" {
+		name = req.Path
+	}
+	name = filepath.Base(name)
+	src, err := os.Open(req.Path)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = src.Stat(); err == nil && info.IsDir() {
+			err = errors.New("is a directory")
+		}
+		defer src.Close()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "Cannot share "+req.Path+": "+err.Error())
+		return
+	}
+
+	dest := filepath.Join(a.pm.Share.UploadsDir, name)
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		writeError(w, http.StatusConflict, "conflict", name+" is already shared")
+		return
+	}
+	if err == nil {
+		_, err = io.Copy(out, src)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.Remove(dest)
+		writeError(w, http.StatusInternalServerError, "internal", "Copy failed: "+err.Error())
+		return
+	}
+	slog.Info("📤 File shared", "file", name)
+
+	files, _ := a.pm.Share.GetFileList()
+	for _, f := range files {
+		if f.Path == "/"+name {
+			writeJSON(w, http.StatusCreated, f)
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, filesystem.FileMeta{Name: name, Path: "/" + name})
+}
+
+func (a *api) listLibrary(w http.ResponseWriter, r *http.Request) {
+	files, err := filesystem.GetDownloadsList()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "Failed to scan library")
+		return
+	}
+	if files == nil {
+		files = []filesystem.FileMeta{}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (a *api) getSettings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, settingsView(nil))
+}
+
+func (a *api) putSettings(w http.ResponseWriter, r *http.Request) {
+	next := config.Active().File()
+	if !decodeBody(w, r, &next) {
+		return
+	}
+	restart, err := config.Active().Replace(next)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_settings", err.Error())
+		return
+	}
+	slog.Info("⚙️  Settings saved")
+	writeJSON(w, http.StatusOK, settingsView(restart))
+}
+
+func (a *api) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec(a.routes()))
+}
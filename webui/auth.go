@@ -0,0 +1,254 @@
+package webui
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenFile in the data dir holds the UI access token. The CLI reads
+	// it to authenticate with "Authorization: Bearer <token>".
+	TokenFile = "ui_token"
+	// CSRFHeader must carry the session's CSRF token on every POST, PUT
+	// and DELETE made with a session cookie
+	CSRFHeader = "X-CSRF-Token"
+
+	sessionCookie = "onivex_session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func tokenEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+type session struct {
+	csrf    string
+	expires time.Time
+}
+
+// Auth guards the control UI. Browsers log in once with the access token
+// and then hold a session cookie; scripts send the token as a bearer token.
+type Auth struct {
+	port  int
+	token string
+
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// LoadAuth reads the access token from dataDir, creating it on first run
+func LoadAuth(dataDir string, port int) (*Auth, error) {
+	path := filepath.Join(dataDir, TokenFile)
+	data, err := os.ReadFile(path)
+	token := strings.TrimSpace(string(data))
+	if os.IsNotExist(err) || token == "" {
+		token = randomHex(32)
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path, []byte(token+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Auth{port: port, token: token, sessions: make(map[string]session)}, nil
+}
+
+// LoginURL opens an authenticated session when visited
+func (a *Auth) LoginURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/login?token=%s", a.port, a.token)
+}
+
+// localHost rejects requests whose Host isn't our loopback address, which
+// is what a DNS rebinding attack looks like
+func localHost(r *http.Request, port int) bool {
+	host, p, err := net.SplitHostPort(r.Host)
+	if err != nil || p != fmt.Sprint(port) {
+		return false
+	}
+	return host == "127.0.0.1" || host == "localhost" || host == "::1"
+}
+
+// sameOrigin rejects requests a browser made on behalf of another site.
+// Only a top-level GET navigation to the page or the login link is let
+// through, so a bookmark or the printed link still opens the UI.
+func (a *Auth) sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		ok := false
+		for _, host := range []string{"127.0.0.1", "localhost", "[::1]"} {
+			if origin == fmt.Sprintf("http://%s:%d", host, a.port) {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return r.Method == http.MethodGet && r.Header.Get("Sec-Fetch-Mode") == "navigate" &&
+			(r.URL.Path == "/" || r.URL.Path == "/login")
+	}
+	return true
+}
+
+func (a *Auth) newSession(w http.ResponseWriter) {
+	id := randomHex(32)
+	a.mu.Lock()
+	now := time.Now()
+	for k, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, k)
+		}
+	}
+	a.sessions[id] = session{csrf: randomHex(32), expires: now.Add(sessionTTL)}
+	a.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *Auth) session(r *http.Request) (session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[c.Value]
+	if !ok || time.Now().After(s.expires) {
+		return session{}, false
+	}
+	return s, true
+}
+
+type csrfKey struct{}
+
+// CSRFToken is the token the page must echo in CSRFHeader, "" for bearer
+// token requests
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>OniVex - Log in</title>
+    <style>
+        body { font-family: 'Inter', sans-serif; background: #020617; color: #f1f5f9; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+        .card { background: #0f172a; border: 1px solid #1e293b; border-radius: 12px; padding: 32px; width: 420px; }
+        .muted { color: #94a3b8; font-size: 13px; }
+        .err { color: #f87171; font-size: 13px; margin-top: 8px; }
+        input { width: 100%; box-sizing: border-box; padding: 8px 12px; margin: 16px 0; border-radius: 8px; border: 1px solid #334155; font-family: monospace; }
+        button { background: #10b981; color: #020617; border: 0; border-radius: 8px; padding: 8px 16px; font-weight: 600; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <form class="card" method="POST" action="/login">
+        <h2>Control UI</h2>
+        <div class="muted">Paste the access token printed when Onivex started. It is also saved in <code>data/ui_token</code>.</div>
+        <input type="password" name="token" autofocus autocomplete="off">
+        <button type="submit">Log in</button>
+        {{if .}}<div class="err">{{.}}</div>{{end}}
+    </form>
+</body>
+</html>`))
+
+func (a *Auth) login(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		token = r.PostFormValue("token")
+	}
+	if tokenEqual(token, a.token) {
+		a.newSession(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	msg := ""
+	if token != "" {
+		msg = "That token is not valid."
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	loginTmpl.Execute(w, msg)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// deny answers in the API's error format under /api/v1, in plain text elsewhere
+func deny(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		writeError(w, status, code, msg)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// Middleware checks Host and Origin on every request, then requires a
+// bearer token or a session cookie. Cookie requests that change state
+// must also carry the session's CSRF token.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localHost(r, a.port) {
+			deny(w, r, http.StatusForbidden, "bad_host", "Bad Host header")
+			return
+		}
+		if !a.sameOrigin(r) {
+			deny(w, r, http.StatusForbidden, "cross_origin", "Cross-origin request refused")
+			return
+		}
+		if r.URL.Path == "/login" {
+			a.login(w, r)
+			return
+		}
+
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if !tokenEqual(bearer, a.token) {
+				deny(w, r, http.StatusUnauthorized, "bad_token", "Bad token")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s, ok := a.session(r)
+		if !ok {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			deny(w, r, http.StatusUnauthorized, "unauthorized", "Log in to the control UI first")
+			return
+		}
+		if !isSafeMethod(r.Method) && !tokenEqual(r.Header.Get(CSRFHeader), s.csrf) {
+			deny(w, r, http.StatusForbidden, "bad_csrf_token", "Missing or bad CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, s.csrf)))
+	})
+}
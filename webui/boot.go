@@ -0,0 +1,67 @@
+package webui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"onivex/network"
+)
+
+var bootTmpl = template.Must(template.New("boot").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="refresh" content="2">
+    <title>OniVex - Starting</title>
+    <style>
+        body { font-family: 'Inter', sans-serif; background: #020617; color: #f1f5f9; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+        .card { background: #0f172a; border: 1px solid #1e293b; border-radius: 12px; padding: 32px; width: 420px; }
+        .bar { height: 8px; background: #1e293b; border-radius: 4px; overflow: hidden; margin: 16px 0 8px; }
+        .fill { height: 100%; background: #10b981; }
+        .muted { color: #94a3b8; font-size: 13px; }
+        .warn { color: #f59e0b; font-size: 13px; margin-top: 8px; }
+    </style>
+</head>
+<body>
+    <div class="card">
+        <h2>Connecting to Tor…</h2>
+        <div class="bar"><div class="fill" style="width: {{.Progress}}%"></div></div>
+        <div class="muted">{{.Progress}}% · {{.Summary}}</div>
+        {{if .Warning}}<div class="warn">{{.Warning}}</div>{{end}}
+    </div>
+</body>
+</html>`))
+
+// StartBootScreen serves a bootstrap progress page on the UI port while Tor
+// starts (which can take minutes over bridges). Call the returned stop func
+// before Start binds the same port.
+func StartBootScreen(port int) func() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tor/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(network.CurrentBootstrap())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		bootTmpl.Execute(w, network.CurrentBootstrap())
+	})
+
+	guarded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localHost(r, port) {
+			http.Error(w, "Bad Host header", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	srv := &http.Server{Addr: fmt.Sprintf("127.0.0.1:%d", port), Handler: guarded}
+	go srv.ListenAndServe()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,360 @@
+package webui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"onivex/config"
+	"onivex/discovery"
+	"onivex/network"
+)
+
+// fetchError is a failed download and the HTTP status that describes it
+type fetchError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *fetchError) Error() string { return e.Msg }
+
+// cleanRemotePath normalises the path of a file on a peer, refusing
+// anything that could climb out of its share
+func cleanRemotePath(filePath string) (string, error) {
+	cleanPath := filepath.Clean(strings.TrimLeft(filePath, "/\\"))
+	if strings.Contains(cleanPath, "..") || filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "/") || strings.HasPrefix(cleanPath, "\\") {
+		slog.Warn("🚨 Blocked path traversal attempt", "path", filePath)
+		return "", &fetchError{http.StatusForbidden, "invalid_path", "Security Violation: Invalid File Path"}
+	}
+	return cleanPath, nil
+}
+
+// createLocal creates a new file for name under downloads/, never
+// replacing one that exists: "a.txt" becomes "a (1).txt" and so on
+func createLocal(name string) (*os.File, string, error) {
+	os.MkdirAll("downloads", 0755)
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 0; ; i++ {
+		localPath := filepath.Join("downloads", base)
+		if i > 0 {
+			localPath = filepath.Join("downloads", fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		}
+		f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, os.ErrExist) {
+			return f, localPath, err
+		}
+	}
+}
+
+// fetchFile copies cleanPath from peer (or from our own share) into out,
+// starting at offset. onStart is told where the copy really starts (0 if
+// the peer ignored the range) and the file's size, -1 if the peer didn't
+// say.
+func fetchFile(ctx context.Context, pm *discovery.PeerManager, t network.Transport, peer, cleanPath string, offset int64, out io.Writer, onStart func(start, size int64)) (int64, error) {
+	if peer == t.Address() {
+		src, err := os.Open(filepath.Join("uploads", cleanPath))
+		if err != nil {
+			return 0, &fetchError{http.StatusNotFound, "not_found", "Local file not found"}
+		}
+		defer src.Close()
+		size := int64(-1)
+		if info, err := src.Stat(); err == nil {
+			size = info.Size()
+		}
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			offset = 0
+		}
+		onStart(offset, size)
+		return io.Copy(out, src)
+	}
+
+	activeDownloads.Inc()
+	defer activeDownloads.Dec()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s/%s", peer, cleanPath), nil)
+	if err != nil {
+		return 0, &fetchError{http.StatusBadRequest, "bad_request", "Request creation failed"}
+	}
+	req.Header.Set("X-Onivex-Version", config.ProtocolVersion)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := pm.NewDownloadClient(config.Current().Network.DownloadTimeout.D()).Do(req)
+	if err != nil {
+		slog.Warn("❌ Download request failed", "path", cleanPath, "err", err)
+		return 0, &fetchError{http.StatusBadGateway, "peer_unreachable", "Connection failed"}
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	default:
+		slog.Warn("❌ Peer refused download", "path", cleanPath, "status", resp.StatusCode)
+		return 0, &fetchError{resp.StatusCode, "peer_error", "Peer returned error"}
+	}
+
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
+	}
+	onStart(offset, size)
+	slog.Debug("✅ Connected, downloading", "path", cleanPath, "offset", offset)
+	n, err := io.Copy(out, resp.Body)
+	if err != nil {
+		slog.Warn("❌ Download interrupted", "path", cleanPath, "err", err)
+	}
+	return n, err
+}
+
+// Download states
+const (
+	DownloadRunning  = "running"
+	DownloadPaused   = "paused"
+	DownloadDone     = "done"
+	DownloadFailed   = "failed"
+	DownloadCanceled = "canceled"
+)
+
+// Download is one file being fetched into the downloads folder
+type Download struct {
+	ID        string     `json:"id"`
+	Peer      string     `json:"peer"`
+	Path      string     `json:"path"`
+	Name      string     `json:"name"`
+	LocalPath string     `json:"local_path"`
+	State     string     `json:"state"`
+	Bytes     int64      `json:"bytes"`
+	Size      int64      `json:"size"` // -1 until known
+	Error     string     `json:"error,omitempty"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+type downloadJob struct {
+	Download
+	cancel context.CancelFunc
+	stop   string // the state a deliberate cancel leaves the job in
+}
+
+// downloadManager runs downloads in the background for /api/v1/downloads
+type downloadManager struct {
+	pm *discovery.PeerManager
+	t  network.Transport
+
+	mu     sync.Mutex
+	nextID int
+	jobs   map[string]*downloadJob
+}
+
+func newDownloadManager(pm *discovery.PeerManager, t network.Transport) *downloadManager {
+	return &downloadManager{pm: pm, t: t, jobs: make(map[string]*downloadJob)}
+}
+
+// progressWriter counts bytes into a job as they are written
+type progressWriter struct {
+	w   io.Writer
+	m   *downloadManager
+	job *downloadJob
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.m.mu.Lock()
+	p.job.Bytes += int64(n)
+	p.m.mu.Unlock()
+	return n, err
+}
+
+var (
+	errNoDownload = errors.New("no such download")
+	errNotRunning = errors.New("download is not running")
+	errNotPaused  = errors.New("download is running or finished")
+)
+
+// Start validates a download and runs it in the background
+func (m *downloadManager) Start(peer, path, name string) (Download, error) {
+	if peer == "" || path == "" {
+		return Download{}, &fetchError{http.StatusBadRequest, "bad_request", "peer and path are required"}
+	}
+	cleanPath, err := cleanRemotePath(path)
+	if err != nil {
+		return Download{}, err
+	}
+	if peer != m.t.Address() && !network.ValidOnion(peer) {
+		return Download{}, &fetchError{http.StatusBadRequest, "bad_request", "peer is not a valid onion address"}
+	}
+	if name == "" {
+		name = filepath.Base(cleanPath)
+	}
+	out, localPath, err := createLocal(name)
+	if err != nil {
+		return Download{}, &fetchError{http.StatusInternalServerError, "internal", "Create file failed"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	job := &downloadJob{Download: Download{
+		ID:        strconv.Itoa(m.nextID),
+		Peer:      peer,
+		Path:      cleanPath,
+		Name:      filepath.Base(localPath),
+		LocalPath: localPath,
+		Size:      -1,
+		Started:   time.Now(),
+	}}
+	m.jobs[job.ID] = job
+	slog.Info("📥 Download started", "file", job.Name, "peer", peer)
+	m.runLocked(job, out)
+	return job.Download, nil
+}
+
+// runLocked fetches the rest of job into out, which is positioned at
+// job.Bytes, in the background
+func (m *downloadManager) runLocked(job *downloadJob, out *os.File) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job.cancel, job.stop = cancel, ""
+	job.State, job.Error, job.Finished = DownloadRunning, "", nil
+	offset := job.Bytes
+
+	go func() {
+		defer cancel()
+		_, err := fetchFile(ctx, m.pm, m.t, job.Peer, job.Path, offset, &progressWriter{out, m, job}, func(start, size int64) {
+			// The peer may have ignored the range, so start over
+			if start != offset {
+				out.Truncate(start)
+				out.Seek(start, io.SeekStart)
+			}
+			m.mu.Lock()
+			job.Bytes, job.Size = start, size
+			m.mu.Unlock()
+		})
+		out.Close()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// A download that finished before the cancel landed is done
+		switch {
+		case err == nil:
+			job.State = DownloadDone
+		case job.stop == DownloadPaused:
+			job.State = DownloadPaused
+			return
+		case job.stop == DownloadCanceled:
+			job.State = DownloadCanceled
+			os.Remove(job.LocalPath)
+		default:
+			// A partial file is kept so Resume can pick it up; an empty one
+			// is only clutter in the library
+			job.State, job.Error = DownloadFailed, err.Error()
+			if job.Bytes == 0 {
+				os.Remove(job.LocalPath)
+			}
+		}
+		now := time.Now()
+		job.Finished = &now
+	}()
+}
+
+func (m *downloadManager) List() []Download {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Download, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		list = append(list, job.Download)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+func (m *downloadManager) Get(id string) (Download, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Download{}, false
+	}
+	return job.Download, true
+}
+
+// Pause stops a running download, keeping what it has fetched so far
+func (m *downloadManager) Pause(id string) (Download, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Download{}, errNoDownload
+	}
+	if job.State != DownloadRunning || job.stop != "" {
+		return job.Download, errNotRunning
+	}
+	job.stop = DownloadPaused
+	job.cancel()
+	return job.Download, nil
+}
+
+// Resume continues a paused or failed download where it stopped. Peers
+// that support range requests send only the missing part.
+func (m *downloadManager) Resume(id string) (Download, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Download{}, errNoDownload
+	}
+	if job.State != DownloadPaused && job.State != DownloadFailed {
+		return job.Download, errNotPaused
+	}
+	out, err := os.OpenFile(job.LocalPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err == nil {
+		job.Bytes, err = out.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return job.Download, &fetchError{http.StatusInternalServerError, "internal", "Reopen file failed"}
+	}
+	slog.Info("▶️  Download resumed", "file", job.Name, "offset", job.Bytes)
+	m.runLocked(job, out)
+	return job.Download, nil
+}
+
+// Remove cancels a running or paused download, or forgets a finished one
+func (m *downloadManager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return errNoDownload
+	}
+	switch job.State {
+	case DownloadRunning:
+		job.stop = DownloadCanceled
+		job.cancel()
+	case DownloadPaused:
+		job.State = DownloadCanceled
+		now := time.Now()
+		job.Finished = &now
+		os.Remove(job.LocalPath)
+	default:
+		// Forgetting a failed download gives up on its partial file
+		if job.State == DownloadFailed {
+			os.Remove(job.LocalPath)
+		}
+		delete(m.jobs, id)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package webui
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"onivex/config"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(config.Duration(0))
+)
+
+// schemaGen turns Go types into JSON Schemas, collecting named structs
+// under components/schemas
+type schemaGen struct {
+	defs map[string]any
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]any{"type": "string", "description": "Go duration, e.g. 90s or 15m"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, done := g.defs[t.Name()]; !done {
+			g.defs[t.Name()] = map[string]any{} // placeholder, in case the type refers to itself
+			g.defs[t.Name()] = g.object(t)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	required := []string{}
+	g.fields(t, props, &required)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// fields follows encoding/json: exported fields only, named by their json
+// tag, with embedded structs flattened. omitempty fields are optional.
+func (g *schemaGen) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func jsonContent(s map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": s}}
+}
+
+// openAPISpec describes routes as an OpenAPI 3 document
+func openAPISpec(routes []apiRoute) map[string]any {
+	g := &schemaGen{defs: map[string]any{}}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content":     jsonContent(g.schema(reflect.TypeOf(APIError{}))),
+	}
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		params := []any{}
+		for _, seg := range strings.Split(rt.Path, "/") {
+			if name, ok := strings.CutPrefix(seg, "{"); ok {
+				params = append(params, map[string]any{
+					"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true,
+					"schema": map[string]any{"type": "string"},
+				})
+			}
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "required": q.Required, "description": q.Description,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		if rt.Response != nil {
+			ok["content"] = jsonContent(g.schema(reflect.TypeOf(rt.Response)))
+		}
+		op := map[string]any{
+			"operationId": rt.ID,
+			"summary":     rt.Summary,
+			"responses":   map[string]any{strconv.Itoa(status): ok, "default": errorResponse},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(g.schema(reflect.TypeOf(rt.Body))),
+			}
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]any{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Onivex control API",
+			"version":     "v1",
+			"description": "Control plane of an Onivex node (protocol " + config.ProtocolVersion + "). Authenticate with the token in data/ui_token as a bearer token.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.defs,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []any{}}},
+	}
+}
@@ -1,45 +1,47 @@
 package webui
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"onivex/config" // <--- IMPORTED
 	"onivex/discovery"
 	"onivex/filesystem"
-
-	"github.com/cretz/bine/tor"
+	"onivex/metrics"
+	"onivex/network"
+	"onivex/transfer"
 )
 
+var activeDownloads = metrics.NewGauge("onivex_active_downloads", "Downloads from peers in progress")
+
 type UIContext struct {
 	MyAddress   string
 	PeerCount   int
 	Peers       []string
 	SearchQuery string
 	Results     []discovery.SearchResult
+	CSRFToken   string
 }
 
-func Start(port int, myAddress string, pm *discovery.PeerManager, t *tor.Tor) {
+// Start serves the control UI behind auth. Our own address is read from the
+// transport on each request since rotating identities change it.
+func Start(port int, auth *Auth, pm *discovery.PeerManager, t network.Transport, uploads *transfer.Uploads) {
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	fmt.Printf("🖥️  Starting Web UI at http://%s\n", addr)
+	slog.Info("🖥️  Starting Web UI", "url", "http://"+addr)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		peers := pm.GetPeers()
 		data := UIContext{
-			MyAddress:   myAddress,
+			MyAddress:   t.Address(),
 			PeerCount:   len(peers),
 			Peers:       peers,
 			SearchQuery: "",
 			Results:     nil,
+			CSRFToken:   CSRFToken(r),
 		}
 
 		tmpl, err := template.ParseGlob("webui/templates/*.html")
@@ -50,142 +52,103 @@ func Start(port int, myAddress string, pm *discovery.PeerManager, t *tor.Tor) {
 		tmpl.ExecuteTemplate(w, "layout.html", data)
 	})
 
-	http.Handle("/library/files/", http.StripPrefix("/library/files/", http.FileServer(http.Dir("downloads"))))
+	pm.RegisterMetrics()
+	uploads.RegisterMetrics()
+	http.Handle("/metrics", metrics.Default)
 
-	http.HandleFunc("/api/library", func(w http.ResponseWriter, r *http.Request) {
-		files, err := filesystem.GetDownloadsList()
-		if err != nil {
-			http.Error(w, "Failed to scan library", 500)
-			return
-		}
+	api := &api{pm: pm, t: t, uploads: uploads, downloads: newDownloadManager(pm, t)}
+	api.register(http.DefaultServeMux)
+
+	// The download limit in effect right now, and the schedule entry that
+	// set it ("" when it is downloads.rate_kib)
+	http.HandleFunc("/api/downloads/limit", func(w http.ResponseWriter, r *http.Request) {
+		rate, window := config.Current().Downloads.RateAt(time.Now())
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(files)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rate_kib":    rate,
+			"window":      window,
+			"total_bytes": pm.Downloads.Total(),
+		})
 	})
 
-	http.HandleFunc("/api/ui/search", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		if query == "" {
-			json.NewEncoder(w).Encode([]discovery.SearchResult{})
-			return
+	http.HandleFunc("/api/tor/status", func(w http.ResponseWriter, r *http.Request) {
+		status := network.CurrentBootstrap()
+		if br, ok := t.(network.BootstrapReporter); ok {
+			status = br.Bootstrap()
 		}
-
-		localFiles := filesystem.SearchLocal(query)
-		networkResults := pm.SearchNetwork(query, myAddress)
-
-		finalResults := []discovery.SearchResult{}
-		if len(localFiles) > 0 {
-			finalResults = append(finalResults, discovery.SearchResult{
-				PeerID: myAddress,
-				Files:  localFiles,
-				Source: "local",
-			})
-		}
-		finalResults = append(finalResults, networkResults...)
-
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(finalResults)
+		json.NewEncoder(w).Encode(status)
 	})
 
-	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
-		peerID := r.URL.Query().Get("peer")
-		filePath := r.URL.Query().Get("path")
-		fileName := r.URL.Query().Get("name")
-
-		if peerID == "" || filePath == "" {
-			http.Error(w, "Missing params", 400)
-			return
-		}
-
-		cleanPath := strings.TrimLeft(filePath, "/\\")
-		cleanPath = filepath.Clean(cleanPath)
-
-		if strings.Contains(cleanPath, "..") || filepath.IsAbs(cleanPath) || strings.HasPrefix(cleanPath, "/") || strings.HasPrefix(cleanPath, "\\") {
-			fmt.Printf("🚨 Security Warning: Blocked path traversal attempt: %s\n", filePath)
-			http.Error(w, "Security Violation: Invalid File Path", 403)
-			return
-		}
-
-		os.MkdirAll("downloads", 0755)
-		localFileName := filepath.Base(fileName)
-		localPath := filepath.Join("downloads", localFileName)
-
-		outFile, err := os.Create(localPath)
-		if err != nil {
-			http.Error(w, "Create file failed", 500)
-			return
-		}
-		defer outFile.Close()
-
-		var bytesWritten int64
-
-		if peerID == myAddress {
-			fmt.Printf("📂 Local Download: %s\n", localFileName)
-			sourcePath := filepath.Join("uploads", cleanPath)
-			sourceFile, err := os.Open(sourcePath)
-			if err != nil {
-				http.Error(w, "Local file not found", 404)
-				return
-			}
-			defer sourceFile.Close()
-			bytesWritten, err = io.Copy(outFile, sourceFile)
-		} else {
-			fmt.Printf("📥 Tor Download: %s from %s\n", localFileName, peerID)
-
-			dialCtx, dialCancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer dialCancel()
-
-			dialer, err := t.Dialer(dialCtx, nil)
-			if err != nil {
-				fmt.Printf("   ❌ Dialer Error: %v\n", err)
-				http.Error(w, "Tor dialer failed", 500)
-				return
-			}
-
-			torClient := &http.Client{
-				Transport: &http.Transport{DialContext: dialer.DialContext},
-				Timeout:   15 * time.Minute,
+	http.HandleFunc("/api/friends", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req struct {
+				Card string `json:"card"`
 			}
-
-			targetURL := fmt.Sprintf("http://%s/%s", peerID, cleanPath)
-
-			// VERSIONED REQUEST
-			req, err := http.NewRequest("GET", targetURL, nil)
-			if err != nil {
-				http.Error(w, "Request creation failed", 500)
-				return
+			json.NewDecoder(r.Body).Decode(&req)
+			card, err := discovery.ParseFriendCard(req.Card)
+			if err == nil {
+				err = pm.Friends.Add(card)
 			}
-			req.Header.Set("X-Onivex-Version", config.ProtocolVersion) // <--- UPDATED
-
-			resp, err := torClient.Do(req)
 			if err != nil {
-				fmt.Printf("   ❌ Request Failed: %v\n", err)
-				http.Error(w, "Connection failed", 502)
+				http.Error(w, err.Error(), 400)
 				return
 			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != 200 {
-				fmt.Printf("   ❌ Peer Error: %d\n", resp.StatusCode)
-				http.Error(w, "Peer returned error", resp.StatusCode)
+			slog.Info("🤝 Added friend", "name", card.Name, "peer", card.Addr)
+			pm.AddPeer(card.Addr)
+		case http.MethodDelete:
+			addr := r.URL.Query().Get("addr")
+			if err := pm.Friends.Remove(addr); err != nil {
+				http.Error(w, err.Error(), 500)
 				return
 			}
-
-			fmt.Printf("   ✅ Connected! Downloading...\n")
-			bytesWritten, err = io.Copy(outFile, resp.Body)
-			if err != nil {
-				fmt.Printf("   ❌ Stream Error: %v\n", err)
+			if pm.FriendsOnly {
+				pm.RemovePeer(addr)
 			}
 		}
 
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = "Onivex node"
+		}
+		myCard := ""
+		if card, err := discovery.NewFriendCard(t, name); err == nil {
+			myCard = card.Encode()
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "success",
-			"path":   localPath,
-			"size":   bytesWritten,
+			"friends_only": pm.FriendsOnly,
+			"friends":      pm.Friends.List(),
+			"my_card":      myCard,
 		})
 	})
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Printf("❌ Web UI failed to start: %v", err)
+	http.Handle("/library/files/", http.StripPrefix("/library/files/", http.FileServer(http.Dir("downloads"))))
+
+	if err := http.ListenAndServe(addr, auth.Middleware(http.DefaultServeMux)); err != nil {
+		slog.Error("❌ Web UI failed to start", "err", err)
+	}
+}
+
+// runSearch looks a query up in our own share and across the mesh, or in
+// the DHT when it is a content hash
+func runSearch(pm *discovery.PeerManager, t network.Transport, query string) []discovery.SearchResult {
+	myAddress := t.Address()
+	if discovery.IsContentHash(query) {
+		return pm.SearchHash(query, myAddress)
 	}
-}
\ No newline at end of file
+
+	localFiles := filesystem.SearchLocal(query)
+	networkResults := pm.SearchNetwork(query, myAddress)
+
+	finalResults := []discovery.SearchResult{}
+	if len(localFiles) > 0 {
+		finalResults = append(finalResults, discovery.SearchResult{
+			PeerID: myAddress,
+			Files:  localFiles,
+			Source: "local",
+		})
+	}
+	return append(finalResults, networkResults...)
+}